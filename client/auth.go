@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/config"
+	"k8s.io/klog/v2"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authCredentials attaches an Authorization header to every RPC. Credentials
+// read from a file are re-read when the file changes.
+type authCredentials struct {
+	authType string
+	path     string
+
+	mu            sync.Mutex
+	modTime       time.Time
+	authorization string
+}
+
+func newAuthCredentials(cfg config.AuthConfig) (*authCredentials, error) {
+	c := &authCredentials{
+		authType: cfg.Type,
+		path:     cfg.CredentialsFile,
+	}
+
+	if c.path != "" {
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	authorization, err := authorizationHeader(c.authType, cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	c.authorization = authorization
+
+	return c, nil
+}
+
+func (c *authCredentials) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	if c.path != "" {
+		if err := c.reloadIfChanged(); err != nil {
+			// keep sending the previous credentials
+			klog.Errorf("reload haproxy credentials error: %v", err.Error())
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return map[string]string{"authorization": c.authorization}, nil
+}
+
+// RequireTransportSecurity is false so plain-text deployments of the
+// configurator keep working.
+func (c *authCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+func (c *authCredentials) reloadIfChanged() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	changed := !info.ModTime().Equal(c.modTime)
+	c.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	klog.Info("HAProxy credentials file changed, reloading...")
+	return c.load()
+}
+
+func (c *authCredentials) load() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return fmt.Errorf("read credentials file: %w", err)
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("read credentials file: %w", err)
+	}
+
+	authorization, err := authorizationHeader(c.authType, string(data))
+	if err != nil {
+		return fmt.Errorf("credentials file %s: %w", c.path, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.modTime = info.ModTime()
+	c.authorization = authorization
+
+	return nil
+}
+
+// authorizationHeader builds the header value from "user:password" (basic)
+// or a token (bearer). Without an explicit type, a value containing a colon
+// is treated as basic credentials.
+func authorizationHeader(authType string, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("credentials are empty")
+	}
+
+	if authType == "" {
+		authType = config.AuthTypeBearer
+		if strings.Contains(value, ":") {
+			authType = config.AuthTypeBasic
+		}
+	}
+
+	switch authType {
+	case config.AuthTypeBasic:
+		if !strings.Contains(value, ":") {
+			return "", fmt.Errorf("basic credentials must be \"user:password\"")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(value)), nil
+	case config.AuthTypeBearer:
+		return "Bearer " + value, nil
+	default:
+		return "", fmt.Errorf("unsupported auth type %q", authType)
+	}
+}
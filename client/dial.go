@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"net"
+	"time"
+)
+
+// Dial creates the gRPC connection to the haproxy-configurator described by cfg.
+func Dial(cfg *config.Config) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{}
+
+	transportCredentials, err := transportCredentials(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, grpc.WithTransportCredentials(transportCredentials))
+
+	if cfg.Auth.CredentialsFile != "" || cfg.Auth.Credentials != "" {
+		perRPCCredentials, err := newAuthCredentials(cfg.Auth)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(perRPCCredentials))
+	}
+
+	opts = append(opts, grpc.WithUnaryInterceptor(timeoutInterceptor(cfg.RequestTimeout.Duration)))
+
+	target := cfg.Endpoints[0]
+	if len(cfg.Endpoints) > 1 {
+		// fail over between endpoints in the configured order
+		r := manual.NewBuilderWithScheme("haproxy-ccm")
+		addresses := make([]resolver.Address, 0, len(cfg.Endpoints))
+		for _, endpoint := range cfg.Endpoints {
+			// verify each endpoint against its own host, not the target
+			host, _, err := net.SplitHostPort(endpoint)
+			if err != nil {
+				host = endpoint
+			}
+			addresses = append(addresses, resolver.Address{Addr: endpoint, ServerName: host})
+		}
+		r.InitialState(resolver.State{Addresses: addresses})
+
+		target = fmt.Sprintf("%s:///haproxy-configurator", r.Scheme())
+		opts = append(opts, grpc.WithResolvers(r))
+	}
+
+	return grpc.NewClient(target, opts...)
+}
+
+// timeoutInterceptor bounds calls whose context has no earlier deadline.
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/config"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/klog/v2"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+func transportCredentials(cfg config.TLSConfig) (credentials.TransportCredentials, error) {
+	if !cfg.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+
+	reloader := &certificateReloader{
+		caFile:   cfg.CAFile,
+		certFile: cfg.CertFile,
+		keyFile:  cfg.KeyFile,
+	}
+	// fail at startup instead of on the first handshake
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CertFile != "" {
+		tlsConfig.GetClientCertificate = reloader.clientCertificate
+	}
+
+	return &reloadingCredentials{config: tlsConfig, reloader: reloader}, nil
+}
+
+// reloadingCredentials verifies every handshake against the CA pool current
+// at that time. The standard verification applies, so IP endpoints are
+// checked against the IP SANs of the certificate.
+type reloadingCredentials struct {
+	config   *tls.Config
+	reloader *certificateReloader
+}
+
+func (c *reloadingCredentials) credentials() credentials.TransportCredentials {
+	if err := c.reloader.reloadIfChanged(); err != nil {
+		klog.Errorf("reload haproxy CA bundle error: %v", err.Error())
+	}
+
+	tlsConfig := c.config.Clone()
+	// nil falls back to the system roots when no CA file is set
+	tlsConfig.RootCAs = c.reloader.roots()
+
+	return credentials.NewTLS(tlsConfig)
+}
+
+func (c *reloadingCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.credentials().ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *reloadingCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.credentials().ServerHandshake(rawConn)
+}
+
+func (c *reloadingCredentials) Info() credentials.ProtocolInfo {
+	return credentials.NewTLS(c.config).Info()
+}
+
+func (c *reloadingCredentials) Clone() credentials.TransportCredentials {
+	return &reloadingCredentials{config: c.config.Clone(), reloader: c.reloader}
+}
+
+func (c *reloadingCredentials) OverrideServerName(serverName string) error {
+	c.config.ServerName = serverName
+	return nil
+}
+
+// certificateReloader re-reads the CA bundle and client key pair whenever
+// one of the files changes, so rotating a mounted Secret takes effect on the
+// next handshake without a restart.
+type certificateReloader struct {
+	caFile   string
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	modTimes    map[string]time.Time
+	caPool      *x509.CertPool
+	certificate *tls.Certificate
+}
+
+func (r *certificateReloader) clientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if err := r.reloadIfChanged(); err != nil {
+		klog.Errorf("reload haproxy client certificate error: %v", err.Error())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.certificate, nil
+}
+
+func (r *certificateReloader) roots() *x509.CertPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.caPool
+}
+
+// reloadIfChanged keeps the previous material when the new files are
+// invalid, e.g. while a Secret update is only half written.
+func (r *certificateReloader) reloadIfChanged() error {
+	r.mu.Lock()
+	changed := false
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		if !info.ModTime().Equal(r.modTimes[path]) {
+			changed = true
+		}
+	}
+	r.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	klog.Info("HAProxy TLS files changed, reloading...")
+	return r.load()
+}
+
+func (r *certificateReloader) load() error {
+	modTimes := map[string]time.Time{}
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	var caPool *x509.CertPool
+	if r.caFile != "" {
+		caPEM, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("read CA file: %w", err)
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificate found in CA file %s", r.caFile)
+		}
+	}
+
+	var certificate *tls.Certificate
+	if r.certFile != "" {
+		keyPair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("load client certificate: %w", err)
+		}
+		certificate = &keyPair
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.modTimes = modTimes
+	r.caPool = caPool
+	r.certificate = certificate
+
+	return nil
+}
+
+func (r *certificateReloader) files() []string {
+	var files []string
+	for _, path := range []string{r.caFile, r.certFile, r.keyFile} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+
+	return files
+}
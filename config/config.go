@@ -0,0 +1,222 @@
+package config
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+const (
+	APIVersion = "haproxy-ccm/v1alpha1"
+	Kind       = "CloudConfig"
+
+	DefaultNamingPrefix     = "haproxy"
+	DefaultBalanceAlgorithm = "roundrobin"
+	DefaultRequestTimeout   = 30 * time.Second
+
+	AuthTypeBasic  = "basic"
+	AuthTypeBearer = "bearer"
+
+	DefaultHealthCheckType = "tcp"
+	DefaultHealthCheckPath = "/"
+
+	BackendModeNode = "node"
+	BackendModePod  = "pod"
+
+	GCModeDelete   = "delete"
+	GCModeReport   = "report"
+	GCModeDisabled = "disabled"
+
+	DefaultGCInterval = 10 * time.Minute
+
+	DefaultIPMode = v1.LoadBalancerIPModeProxy
+
+	DefaultHTTPRequestTimeout   = 10 * time.Second
+	DefaultHTTPKeepAliveTimeout = 10 * time.Second
+	DefaultHTTPTunnelTimeout    = time.Hour
+)
+
+// Config is the content of the file passed with --cloud-config.
+//
+//	apiVersion: haproxy-ccm/v1alpha1
+//	kind: CloudConfig
+//	endpoints:
+//	  - haproxy-configurator:50051
+//	tls:
+//	  enabled: true
+//	  caFile: /etc/haproxy-ccm/tls/ca.crt
+//	auth:
+//	  type: basic
+//	  credentialsFile: /etc/haproxy-ccm/auth/credentials
+//	requestTimeout: 30s
+//	ipPools:
+//	  - 192.0.2.0/28
+//	defaultBalanceAlgorithm: roundrobin
+//	nodeAddressPreference:
+//	  - InternalIP
+//	backendMode: node
+//	ipMode: Proxy
+//	healthCheck:
+//	  type: tcp
+//	  interval: 2s
+//	  rise: 2
+//	  fall: 3
+//	httpTimeouts:
+//	  request: 10s
+//	  keepAlive: 10s
+//	  tunnel: 1h
+//	namingPrefix: haproxy
+//	clusterID: production
+//	garbageCollection:
+//	  mode: delete
+//	  interval: 10m
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// Endpoints of the haproxy-configurator gRPC API. The first reachable
+	// one is used.
+	Endpoints []string   `json:"endpoints,omitempty"`
+	TLS       TLSConfig  `json:"tls,omitempty"`
+	Auth      AuthConfig `json:"auth,omitempty"`
+
+	// RequestTimeout bounds every call to the configurator.
+	RequestTimeout metav1.Duration `json:"requestTimeout,omitempty"`
+
+	// IPPools are CIDRs or address ranges load balancer IPs are allocated from.
+	IPPools []string `json:"ipPools,omitempty"`
+
+	DefaultBalanceAlgorithm string `json:"defaultBalanceAlgorithm,omitempty"`
+
+	// NodeAddressPreference is the ordered list of node address types used
+	// as backend server addresses.
+	NodeAddressPreference []v1.NodeAddressType `json:"nodeAddressPreference,omitempty"`
+
+	// BackendMode is "node" to send traffic to the NodePorts of the nodes or
+	// "pod" to send it to the ready endpoints directly, which requires
+	// routable pod IPs. Services override it with an annotation.
+	BackendMode string `json:"backendMode,omitempty"`
+
+	// IPMode is reported for every load balancer address. "Proxy" makes
+	// clients inside the cluster go through HAProxy, while "VIP" lets
+	// kube-proxy send their traffic to the endpoints directly.
+	IPMode v1.LoadBalancerIPMode `json:"ipMode,omitempty"`
+
+	// HealthCheck is the default check of the servers, overridden per
+	// Service with annotations.
+	HealthCheck HealthCheckConfig `json:"healthCheck,omitempty"`
+
+	// HTTPTimeouts apply to the ports served in HTTP mode.
+	HTTPTimeouts HTTPTimeoutsConfig `json:"httpTimeouts,omitempty"`
+
+	// NamingPrefix is prepended to every HAProxy object the provider creates.
+	NamingPrefix string `json:"namingPrefix,omitempty"`
+
+	// ClusterID follows the naming prefix in every object name, so that
+	// several clusters can share one HAProxy. Defaults to --cluster-name.
+	ClusterID string `json:"clusterID,omitempty"`
+
+	GarbageCollection GarbageCollectionConfig `json:"garbageCollection,omitempty"`
+}
+
+type TLSConfig struct {
+	Enabled    bool   `json:"enabled,omitempty"`
+	CAFile     string `json:"caFile,omitempty"`
+	CertFile   string `json:"certFile,omitempty"`
+	KeyFile    string `json:"keyFile,omitempty"`
+	ServerName string `json:"serverName,omitempty"`
+}
+
+type AuthConfig struct {
+	// Type is "basic" or "bearer". When empty, credentials containing a
+	// colon are sent as basic auth and anything else as a bearer token.
+	Type string `json:"type,omitempty"`
+
+	// CredentialsFile holds either "user:password" or a token. It is
+	// re-read when it changes.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+
+	// Credentials come from $HAPROXY_AUTH and are never read from the
+	// config file.
+	Credentials string `json:"-"`
+}
+
+// HealthCheckConfig is how servers are checked. Zero values leave the
+// HAProxy defaults in place.
+type HealthCheckConfig struct {
+	// Type is "tcp", "http" or "none".
+	Type string `json:"type,omitempty"`
+	// Path is the URI requested by HTTP checks, and ExpectedStatus the
+	// status they expect instead of any 2xx or 3xx.
+	Path           string `json:"path,omitempty"`
+	ExpectedStatus int32  `json:"expectedStatus,omitempty"`
+	SSL            bool   `json:"ssl,omitempty"`
+
+	Interval metav1.Duration `json:"interval,omitempty"`
+	Timeout  metav1.Duration `json:"timeout,omitempty"`
+	Rise     int32           `json:"rise,omitempty"`
+	Fall     int32           `json:"fall,omitempty"`
+}
+
+// HTTPTimeoutsConfig are the timeouts of ports in HTTP mode.
+type HTTPTimeoutsConfig struct {
+	// Request bounds the time a client takes to send a complete request.
+	Request metav1.Duration `json:"request,omitempty"`
+	// KeepAlive bounds the wait for the next request on an idle connection.
+	KeepAlive metav1.Duration `json:"keepAlive,omitempty"`
+	// Tunnel bounds the inactivity of upgraded connections such as
+	// WebSockets.
+	Tunnel metav1.Duration `json:"tunnel,omitempty"`
+}
+
+// GarbageCollectionConfig controls the sweep for HAProxy objects whose
+// Service no longer exists.
+type GarbageCollectionConfig struct {
+	// Mode is "report" (log orphans only), "delete" or "disabled". Deleting
+	// requires ClusterID, so that the sweep never mistakes the objects of
+	// another cluster with the same --cluster-name for orphans.
+	Mode     string          `json:"mode,omitempty"`
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+func (c *Config) SetDefaults() {
+	if c.RequestTimeout.Duration == 0 {
+		c.RequestTimeout.Duration = DefaultRequestTimeout
+	}
+	if c.DefaultBalanceAlgorithm == "" {
+		c.DefaultBalanceAlgorithm = DefaultBalanceAlgorithm
+	}
+	if len(c.NodeAddressPreference) == 0 {
+		c.NodeAddressPreference = []v1.NodeAddressType{v1.NodeInternalIP}
+	}
+	if c.BackendMode == "" {
+		c.BackendMode = BackendModeNode
+	}
+	if c.IPMode == "" {
+		c.IPMode = DefaultIPMode
+	}
+	if c.HealthCheck.Type == "" {
+		c.HealthCheck.Type = DefaultHealthCheckType
+	}
+	if c.HealthCheck.Type == "http" && c.HealthCheck.Path == "" {
+		c.HealthCheck.Path = DefaultHealthCheckPath
+	}
+	if c.HTTPTimeouts.Request.Duration == 0 {
+		c.HTTPTimeouts.Request.Duration = DefaultHTTPRequestTimeout
+	}
+	if c.HTTPTimeouts.KeepAlive.Duration == 0 {
+		c.HTTPTimeouts.KeepAlive.Duration = DefaultHTTPKeepAliveTimeout
+	}
+	if c.HTTPTimeouts.Tunnel.Duration == 0 {
+		c.HTTPTimeouts.Tunnel.Duration = DefaultHTTPTunnelTimeout
+	}
+	if c.NamingPrefix == "" {
+		c.NamingPrefix = DefaultNamingPrefix
+	}
+	if c.GarbageCollection.Mode == "" {
+		c.GarbageCollection.Mode = GCModeReport
+	}
+	if c.GarbageCollection.Interval.Duration == 0 {
+		c.GarbageCollection.Interval.Duration = DefaultGCInterval
+	}
+}
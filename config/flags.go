@@ -0,0 +1,110 @@
+package config
+
+import (
+	"github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"strings"
+	"time"
+)
+
+// Flags are the command line flags of the "haproxy" flag set. A flag given
+// on the command line overrides the cloud config, while the environment
+// variable defaults only fill fields the cloud config leaves empty.
+type Flags struct {
+	Endpoint        string
+	IPPools         string
+	TLSEnabled      bool
+	CAFile          string
+	CertFile        string
+	KeyFile         string
+	ServerName      string
+	AuthType        string
+	CredentialsFile string
+	RequestTimeout  time.Duration
+	ClusterID       string
+	IPMode          string
+	GCMode          string
+	GCInterval      time.Duration
+
+	fs *pflag.FlagSet
+}
+
+func (f *Flags) AddFlags(fs *pflag.FlagSet) {
+	f.fs = fs
+
+	fs.StringVar(&f.Endpoint, "haproxy-endpoint", os.Getenv("HAPROXY_ENDPOINT"), "The endpoint of the haproxy gRPC API. Defaults to $HAPROXY_ENDPOINT.")
+	fs.StringVar(&f.IPPools, "haproxy-ip-pools", os.Getenv("HAPROXY_IP_POOLS"), "Comma separated CIDRs or address ranges to allocate load balancer IPs from. Defaults to $HAPROXY_IP_POOLS.")
+	fs.BoolVar(&f.TLSEnabled, "haproxy-tls", false, "Use TLS for the connection to the haproxy gRPC API.")
+	fs.StringVar(&f.CAFile, "haproxy-ca-file", "", "CA bundle used to verify the haproxy gRPC API server certificate.")
+	fs.StringVar(&f.CertFile, "haproxy-cert-file", "", "Client certificate for mutual TLS with the haproxy gRPC API.")
+	fs.StringVar(&f.KeyFile, "haproxy-key-file", "", "Client private key for mutual TLS with the haproxy gRPC API.")
+	fs.StringVar(&f.ServerName, "haproxy-server-name", "", "Override the server name used to verify the haproxy gRPC API certificate.")
+	fs.StringVar(&f.AuthType, "haproxy-auth-type", "", "Authentication scheme for the haproxy gRPC API, \"basic\" or \"bearer\". Detected from the credentials when empty.")
+	fs.StringVar(&f.CredentialsFile, "haproxy-credentials-file", "", "File holding \"user:password\" or a bearer token for the haproxy gRPC API. Takes precedence over $HAPROXY_AUTH.")
+	fs.DurationVar(&f.RequestTimeout, "haproxy-request-timeout", DefaultRequestTimeout, "Timeout of a single call to the haproxy gRPC API.")
+	fs.StringVar(&f.ClusterID, "haproxy-cluster-id", "", "Identifies this cluster in the names of HAProxy objects. Defaults to --cluster-name.")
+	fs.StringVar(&f.IPMode, "haproxy-ip-mode", string(DefaultIPMode), "IP mode reported for load balancer addresses, \"Proxy\" or \"VIP\".")
+	fs.StringVar(&f.GCMode, "haproxy-gc-mode", GCModeReport, "What to do with HAProxy resources whose Service no longer exists: \"report\", \"delete\" (requires a cluster ID) or \"disabled\".")
+	fs.DurationVar(&f.GCInterval, "haproxy-gc-interval", DefaultGCInterval, "Interval of the sweep for orphaned HAProxy resources.")
+}
+
+// ApplyTo merges the flags into cfg.
+func (f *Flags) ApplyTo(cfg *Config) {
+	if f.changed("haproxy-endpoint") || (len(cfg.Endpoints) == 0 && f.Endpoint != "") {
+		cfg.Endpoints = []string{f.Endpoint}
+	}
+	if f.changed("haproxy-ip-pools") || (len(cfg.IPPools) == 0 && f.IPPools != "") {
+		cfg.IPPools = nil
+		for _, pool := range strings.Split(f.IPPools, ",") {
+			if strings.TrimSpace(pool) != "" {
+				cfg.IPPools = append(cfg.IPPools, pool)
+			}
+		}
+	}
+
+	if f.changed("haproxy-tls") {
+		cfg.TLS.Enabled = f.TLSEnabled
+	}
+	if f.changed("haproxy-ca-file") {
+		cfg.TLS.CAFile = f.CAFile
+	}
+	if f.changed("haproxy-cert-file") {
+		cfg.TLS.CertFile = f.CertFile
+	}
+	if f.changed("haproxy-key-file") {
+		cfg.TLS.KeyFile = f.KeyFile
+	}
+	if f.changed("haproxy-server-name") {
+		cfg.TLS.ServerName = f.ServerName
+	}
+	if f.changed("haproxy-auth-type") {
+		cfg.Auth.Type = f.AuthType
+	}
+	if f.changed("haproxy-credentials-file") {
+		cfg.Auth.CredentialsFile = f.CredentialsFile
+	}
+	if cfg.Auth.CredentialsFile == "" {
+		cfg.Auth.Credentials = os.Getenv("HAPROXY_AUTH")
+	}
+	if f.changed("haproxy-request-timeout") {
+		cfg.RequestTimeout = metav1.Duration{Duration: f.RequestTimeout}
+	}
+	if f.changed("haproxy-cluster-id") {
+		cfg.ClusterID = f.ClusterID
+	}
+	if f.changed("haproxy-ip-mode") {
+		cfg.IPMode = v1.LoadBalancerIPMode(f.IPMode)
+	}
+	if f.changed("haproxy-gc-mode") {
+		cfg.GarbageCollection.Mode = f.GCMode
+	}
+	if f.changed("haproxy-gc-interval") {
+		cfg.GarbageCollection.Interval = metav1.Duration{Duration: f.GCInterval}
+	}
+}
+
+func (f *Flags) changed(name string) bool {
+	return f.fs != nil && f.fs.Changed(name)
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/ipam"
+	"github.com/bear-san/haproxy-ccm/model"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"os"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"time"
+)
+
+var namingPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// maxNamingPrefixLength leaves room for the cluster in the names before it
+// has to be hashed.
+const maxNamingPrefixLength = 16
+
+// Load decodes a YAML or JSON config from r. A nil or empty reader yields
+// the defaults, so the provider still works without --cloud-config.
+func Load(r io.Reader) (*Config, error) {
+	cfg := &Config{APIVersion: APIVersion, Kind: Kind}
+
+	if r != nil {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("read cloud config: %w", err)
+		}
+
+		if strings.TrimSpace(string(data)) != "" {
+			cfg = &Config{}
+			if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+				return nil, fmt.Errorf("decode cloud config: %w", err)
+			}
+		}
+	}
+
+	if cfg.APIVersion != APIVersion {
+		return nil, fmt.Errorf("unsupported cloud config apiVersion %q, expected %q", cfg.APIVersion, APIVersion)
+	}
+	if cfg.Kind != Kind {
+		return nil, fmt.Errorf("unsupported cloud config kind %q, expected %q", cfg.Kind, Kind)
+	}
+
+	cfg.SetDefaults()
+
+	return cfg, nil
+}
+
+// Validate reports every problem of the config at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.Endpoints) == 0 {
+		errs = append(errs, errors.New("endpoints: at least one haproxy endpoint is required"))
+	}
+	for i, endpoint := range c.Endpoints {
+		if strings.TrimSpace(endpoint) == "" {
+			errs = append(errs, fmt.Errorf("endpoints[%d]: must not be empty", i))
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: certFile and keyFile must be set together"))
+	}
+	if !c.TLS.Enabled && (c.TLS.CAFile != "" || c.TLS.CertFile != "" || c.TLS.ServerName != "") {
+		errs = append(errs, errors.New("tls: caFile, certFile and serverName require enabled: true"))
+	}
+	if c.Auth.Type != "" && c.Auth.Type != AuthTypeBasic && c.Auth.Type != AuthTypeBearer {
+		errs = append(errs, fmt.Errorf("auth.type: must be %q or %q, got %q", AuthTypeBasic, AuthTypeBearer, c.Auth.Type))
+	}
+
+	for _, file := range []struct{ field, path string }{
+		{"tls.caFile", c.TLS.CAFile},
+		{"tls.certFile", c.TLS.CertFile},
+		{"tls.keyFile", c.TLS.KeyFile},
+		{"auth.credentialsFile", c.Auth.CredentialsFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.field, err))
+		}
+	}
+
+	if c.RequestTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("requestTimeout: must be positive, got %s", c.RequestTimeout.Duration))
+	}
+
+	for i, pool := range c.IPPools {
+		if _, err := ipam.ParsePool(pool); err != nil {
+			errs = append(errs, fmt.Errorf("ipPools[%d]: %w", i, err))
+		}
+	}
+
+	for i, addressType := range c.NodeAddressPreference {
+		if addressType != v1.NodeInternalIP && addressType != v1.NodeExternalIP {
+			errs = append(errs, fmt.Errorf("nodeAddressPreference[%d]: unsupported address type %q", i, addressType))
+		}
+	}
+
+	if c.BackendMode != BackendModeNode && c.BackendMode != BackendModePod {
+		errs = append(errs, fmt.Errorf("backendMode: must be %q or %q, got %q", BackendModeNode, BackendModePod, c.BackendMode))
+	}
+
+	if c.IPMode != v1.LoadBalancerIPModeProxy && c.IPMode != v1.LoadBalancerIPModeVIP {
+		errs = append(errs, fmt.Errorf("ipMode: must be %q or %q, got %q", v1.LoadBalancerIPModeProxy, v1.LoadBalancerIPModeVIP, c.IPMode))
+	}
+
+	if _, err := model.ParseCheckType(c.HealthCheck.Type); err != nil {
+		errs = append(errs, fmt.Errorf("healthCheck.type: %w", err))
+	}
+	if c.HealthCheck.Path != "" && !strings.HasPrefix(c.HealthCheck.Path, "/") {
+		errs = append(errs, fmt.Errorf("healthCheck.path: must start with \"/\", got %q", c.HealthCheck.Path))
+	}
+	if status := c.HealthCheck.ExpectedStatus; status != 0 && (status < 100 || status > 599) {
+		errs = append(errs, fmt.Errorf("healthCheck.expectedStatus: must be an HTTP status code, got %d", status))
+	}
+	for _, duration := range []struct {
+		field string
+		value time.Duration
+	}{
+		{"healthCheck.interval", c.HealthCheck.Interval.Duration},
+		{"healthCheck.timeout", c.HealthCheck.Timeout.Duration},
+		{"httpTimeouts.request", c.HTTPTimeouts.Request.Duration},
+		{"httpTimeouts.keepAlive", c.HTTPTimeouts.KeepAlive.Duration},
+		{"httpTimeouts.tunnel", c.HTTPTimeouts.Tunnel.Duration},
+	} {
+		if duration.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative, got %s", duration.field, duration.value))
+		}
+	}
+	if c.HealthCheck.Rise < 0 || c.HealthCheck.Fall < 0 {
+		errs = append(errs, errors.New("healthCheck: rise and fall must not be negative"))
+	}
+
+	if !namingPrefixPattern.MatchString(c.NamingPrefix) {
+		errs = append(errs, fmt.Errorf("namingPrefix: %q must start with a letter or digit and contain only letters, digits, '-', '_' and '.'", c.NamingPrefix))
+	}
+	if len(c.NamingPrefix) > maxNamingPrefixLength {
+		errs = append(errs, fmt.Errorf("namingPrefix: %q must not be longer than %d characters", c.NamingPrefix, maxNamingPrefixLength))
+	}
+
+	if c.ClusterID != "" && !namingPrefixPattern.MatchString(c.ClusterID) {
+		errs = append(errs, fmt.Errorf("clusterID: %q must start with a letter or digit and contain only letters, digits, '-', '_' and '.'", c.ClusterID))
+	}
+
+	switch c.GarbageCollection.Mode {
+	case GCModeDelete, GCModeReport, GCModeDisabled:
+	default:
+		errs = append(errs, fmt.Errorf("garbageCollection.mode: must be %q, %q or %q, got %q", GCModeDelete, GCModeReport, GCModeDisabled, c.GarbageCollection.Mode))
+	}
+	if c.GarbageCollection.Mode == GCModeDelete && c.ClusterID == "" {
+		errs = append(errs, fmt.Errorf("garbageCollection.mode: %q requires clusterID", GCModeDelete))
+	}
+	if c.GarbageCollection.Interval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("garbageCollection.interval: must be positive, got %s", c.GarbageCollection.Interval.Duration))
+	}
+
+	return errors.Join(errs...)
+}
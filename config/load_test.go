@@ -0,0 +1,219 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "empty", data: ""},
+		{name: "whitespace", data: "\n  \n"},
+		{
+			name: "yaml",
+			data: "apiVersion: haproxy-ccm/v1alpha1\nkind: CloudConfig\nendpoints:\n  - haproxy:50051\n",
+		},
+		{
+			name: "json",
+			data: `{"apiVersion": "haproxy-ccm/v1alpha1", "kind": "CloudConfig", "endpoints": ["haproxy:50051"]}`,
+		},
+		{
+			name:    "wrong apiVersion",
+			data:    "apiVersion: haproxy-ccm/v1\nkind: CloudConfig\n",
+			wantErr: `unsupported cloud config apiVersion "haproxy-ccm/v1"`,
+		},
+		{
+			name:    "missing apiVersion",
+			data:    "kind: CloudConfig\n",
+			wantErr: `unsupported cloud config apiVersion ""`,
+		},
+		{
+			name:    "wrong kind",
+			data:    "apiVersion: haproxy-ccm/v1alpha1\nkind: Config\n",
+			wantErr: `unsupported cloud config kind "Config"`,
+		},
+		{
+			name:    "unknown field",
+			data:    "apiVersion: haproxy-ccm/v1alpha1\nkind: CloudConfig\nendpoint: haproxy:50051\n",
+			wantErr: `unknown field "endpoint"`,
+		},
+		{
+			name:    "unknown nested field",
+			data:    "apiVersion: haproxy-ccm/v1alpha1\nkind: CloudConfig\ntls:\n  insecure: true\n",
+			wantErr: `unknown field "insecure"`,
+		},
+		{
+			name:    "wrong type",
+			data:    "apiVersion: haproxy-ccm/v1alpha1\nkind: CloudConfig\nendpoints: haproxy:50051\n",
+			wantErr: "decode cloud config",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(strings.NewReader(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			// defaults are applied
+			if cfg.NamingPrefix != DefaultNamingPrefix || cfg.DefaultBalanceAlgorithm != DefaultBalanceAlgorithm {
+				t.Errorf("Load() = %+v, want the defaults applied", cfg)
+			}
+		})
+	}
+}
+
+func TestLoadNil(t *testing.T) {
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load(nil) error = %v", err)
+	}
+	if cfg.APIVersion != APIVersion || cfg.Kind != Kind {
+		t.Errorf("Load(nil) = %s %s, want %s %s", cfg.APIVersion, cfg.Kind, APIVersion, Kind)
+	}
+}
+
+// validConfig returns a config that passes Validate.
+func validConfig() *Config {
+	cfg := &Config{APIVersion: APIVersion, Kind: Kind, Endpoints: []string{"haproxy:50051"}}
+	cfg.SetDefaults()
+
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		modify   func(cfg *Config)
+		wantErrs []string
+	}{
+		{
+			name:   "valid",
+			modify: func(*Config) {},
+		},
+		{
+			name: "valid with every section",
+			modify: func(cfg *Config) {
+				cfg.TLS = TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, ServerName: "haproxy"}
+				cfg.IPPools = []string{"192.0.2.0/28", "2001:db8::10-2001:db8::1f"}
+				cfg.NamingPrefix = "lb"
+				cfg.ClusterID = "prod.eu-1"
+			},
+		},
+		{
+			name:     "no endpoint",
+			modify:   func(cfg *Config) { cfg.Endpoints = nil },
+			wantErrs: []string{"endpoints: at least one haproxy endpoint is required"},
+		},
+		{
+			name:     "empty endpoint",
+			modify:   func(cfg *Config) { cfg.Endpoints = append(cfg.Endpoints, " ") },
+			wantErrs: []string{"endpoints[1]: must not be empty"},
+		},
+		{
+			name:     "certFile without keyFile",
+			modify:   func(cfg *Config) { cfg.TLS = TLSConfig{Enabled: true, CertFile: certFile} },
+			wantErrs: []string{"tls: certFile and keyFile must be set together"},
+		},
+		{
+			name:     "keyFile without certFile",
+			modify:   func(cfg *Config) { cfg.TLS = TLSConfig{Enabled: true, KeyFile: keyFile} },
+			wantErrs: []string{"tls: certFile and keyFile must be set together"},
+		},
+		{
+			name:     "TLS files without TLS",
+			modify:   func(cfg *Config) { cfg.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile} },
+			wantErrs: []string{"tls: caFile, certFile and serverName require enabled: true"},
+		},
+		{
+			name:     "missing CA file",
+			modify:   func(cfg *Config) { cfg.TLS = TLSConfig{Enabled: true, CAFile: filepath.Join(dir, "missing.crt")} },
+			wantErrs: []string{"tls.caFile: "},
+		},
+		{
+			name:     "invalid pool",
+			modify:   func(cfg *Config) { cfg.IPPools = []string{"192.0.2.0/28", "192.0.2.20-192.0.2.10"} },
+			wantErrs: []string{"ipPools[1]: invalid pool"},
+		},
+		{
+			name:     "invalid namingPrefix",
+			modify:   func(cfg *Config) { cfg.NamingPrefix = "-lb" },
+			wantErrs: []string{`namingPrefix: "-lb" must start with a letter or digit`},
+		},
+		{
+			name:     "namingPrefix with a slash",
+			modify:   func(cfg *Config) { cfg.NamingPrefix = "lb/1" },
+			wantErrs: []string{`namingPrefix: "lb/1" must start with a letter or digit`},
+		},
+		{
+			name:     "long namingPrefix",
+			modify:   func(cfg *Config) { cfg.NamingPrefix = strings.Repeat("a", 17) },
+			wantErrs: []string{"must not be longer than 16 characters"},
+		},
+		{
+			name:     "invalid clusterID",
+			modify:   func(cfg *Config) { cfg.ClusterID = "prod cluster" },
+			wantErrs: []string{`clusterID: "prod cluster" must start with a letter or digit`},
+		},
+		{
+			name: "every error at once",
+			modify: func(cfg *Config) {
+				cfg.Endpoints = nil
+				cfg.TLS = TLSConfig{Enabled: true, CertFile: certFile}
+				cfg.IPPools = []string{"not-a-pool"}
+				cfg.NamingPrefix = "_"
+				cfg.ClusterID = "a b"
+			},
+			wantErrs: []string{
+				"endpoints:",
+				"tls: certFile and keyFile",
+				"ipPools[0]:",
+				"namingPrefix:",
+				"clusterID:",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want %q", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() error = %v, want it to contain %q", err, want)
+				}
+			}
+			if got := len(strings.Split(err.Error(), "\n")); got != len(tt.wantErrs) {
+				t.Errorf("Validate() reported %d errors, want %d: %v", got, len(tt.wantErrs), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package controllers
+
+const (
+	// AnnotationLoadBalancerIPs requests specific VIPs for a Service, as a
+	// comma separated list. It takes precedence over spec.loadBalancerIP.
+	AnnotationLoadBalancerIPs = "haproxy-ccm/load-balancer-ips"
+
+	// AnnotationBackendMode is "node" to send traffic to the NodePorts or
+	// "pod" to send it to the ready endpoints directly. It overrides the
+	// backendMode of the cloud config.
+	AnnotationBackendMode = "haproxy-ccm/backend-mode"
+
+	// AnnotationBalanceAlgorithm selects the HAProxy balance algorithm, e.g.
+	// "leastconn" or "hdr(Host)". It overrides the defaultBalanceAlgorithm
+	// of the cloud config.
+	AnnotationBalanceAlgorithm = "haproxy-ccm/balance-algorithm"
+
+	// AnnotationHTTPPorts lists the names or numbers of the ports served in
+	// HTTP mode, separated by commas. Ports with the appProtocol "http",
+	// "kubernetes.io/h2c" or "kubernetes.io/ws" are always served in HTTP
+	// mode.
+	AnnotationHTTPPorts = "haproxy-ccm/http-ports"
+
+	// The health check annotations override the healthCheck defaults of
+	// the cloud config. Services with the Local traffic policy in the node
+	// backend mode are always checked on their health check NodePort, so
+	// only the interval, timeout, rise and fall apply to them.
+	AnnotationHealthCheckType           = "haproxy-ccm/health-check-type"
+	AnnotationHealthCheckPath           = "haproxy-ccm/health-check-path"
+	AnnotationHealthCheckExpectedStatus = "haproxy-ccm/health-check-expected-status"
+	AnnotationHealthCheckSSL            = "haproxy-ccm/health-check-ssl"
+	AnnotationHealthCheckInterval       = "haproxy-ccm/health-check-interval"
+	AnnotationHealthCheckTimeout        = "haproxy-ccm/health-check-timeout"
+	AnnotationHealthCheckRise           = "haproxy-ccm/health-check-rise"
+	AnnotationHealthCheckFall           = "haproxy-ccm/health-check-fall"
+)
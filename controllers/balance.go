@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/model"
+	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"strings"
+)
+
+var balanceAlgorithms = map[string]haproxyv1.BalanceAlgorithm{
+	"roundrobin": haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_ROUNDROBIN,
+	"static-rr":  haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_STATIC_RR,
+	"leastconn":  haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_LEASTCONN,
+	"first":      haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_FIRST,
+	"source":     haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_SOURCE,
+	"random":     haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_RANDOM,
+	"uri":        haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_URI,
+	"url_param":  haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_URL_PARAM,
+	"hdr":        haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_HDR,
+}
+
+// httpBalanceAlgorithms only work in backends in HTTP mode.
+var httpBalanceAlgorithms = sets.New("uri", "url_param", "hdr")
+
+// ParseBalanceAlgorithm maps an HAProxy balance algorithm to the
+// configurator API. "hdr" and "url_param" take their argument in
+// parentheses, e.g. "hdr(Host)".
+func ParseBalanceAlgorithm(value string) (*haproxyv1.BackendBalance, error) {
+	name, argument, hasArgument := strings.Cut(value, "(")
+	if hasArgument {
+		var ok bool
+		if argument, ok = strings.CutSuffix(argument, ")"); !ok || argument == "" {
+			return nil, fmt.Errorf("invalid balance algorithm %q", value)
+		}
+	}
+
+	algorithm, ok := balanceAlgorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported balance algorithm %q", value)
+	}
+
+	balance := &haproxyv1.BackendBalance{Algorithm: algorithm}
+	switch name {
+	case "hdr", "url_param":
+		if !hasArgument {
+			return nil, fmt.Errorf("balance algorithm %q takes an argument in parentheses, e.g. %s(name)", name, name)
+		}
+		if name == "hdr" {
+			balance.HdrName = argument
+		} else {
+			balance.UrlParam = argument
+		}
+	default:
+		if hasArgument {
+			return nil, fmt.Errorf("balance algorithm %q takes no argument", name)
+		}
+	}
+
+	return balance, nil
+}
+
+// formatBalanceAlgorithm is the inverse of ParseBalanceAlgorithm.
+func formatBalanceAlgorithm(balance *haproxyv1.BackendBalance) string {
+	for name, algorithm := range balanceAlgorithms {
+		if algorithm != balance.GetAlgorithm() {
+			continue
+		}
+		switch name {
+		case "hdr":
+			return fmt.Sprintf("%s(%s)", name, balance.GetHdrName())
+		case "url_param":
+			return fmt.Sprintf("%s(%s)", name, balance.GetUrlParam())
+		default:
+			return name
+		}
+	}
+
+	return ""
+}
+
+// balanceAlgorithm returns the algorithm requested by the annotation of
+// service, or defaultAlgorithm without it.
+func balanceAlgorithm(service *v1.Service, defaultAlgorithm string) (string, error) {
+	value, ok := service.Annotations[AnnotationBalanceAlgorithm]
+	if !ok {
+		return defaultAlgorithm, nil
+	}
+
+	value = strings.TrimSpace(value)
+	if _, err := ParseBalanceAlgorithm(value); err != nil {
+		return "", fmt.Errorf("annotation %s: %w", AnnotationBalanceAlgorithm, err)
+	}
+
+	return value, nil
+}
+
+// RequiresHTTPMode reports whether HAProxy replaces the balance algorithm
+// by roundrobin in backends not in HTTP mode.
+func RequiresHTTPMode(value string) bool {
+	name, _, _ := strings.Cut(value, "(")
+	return httpBalanceAlgorithms.Has(name)
+}
+
+// validateBalance rejects algorithms that HAProxy would silently replace
+// by roundrobin in backends not in HTTP mode.
+func validateBalance(backend *model.Backend) error {
+	if RequiresHTTPMode(backend.Balance) && backend.Mode != model.ModeHTTP {
+		return fmt.Errorf("balance algorithm %q requires HTTP mode", backend.Balance)
+	}
+
+	return nil
+}
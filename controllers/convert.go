@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"github.com/bear-san/haproxy-ccm/model"
+	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
+	"slices"
+	"strings"
+	"time"
+)
+
+// conversions between the desired-state model and the configurator API
+
+var proxyModes = map[model.Mode]haproxyv1.ProxyMode{
+	model.ModeTCP:  haproxyv1.ProxyMode_PROXY_MODE_TCP,
+	model.ModeHTTP: haproxyv1.ProxyMode_PROXY_MODE_HTTP,
+}
+
+func toProxyMode(mode model.Mode) haproxyv1.ProxyMode {
+	return proxyModes[mode]
+}
+
+func fromProxyMode(mode haproxyv1.ProxyMode) model.Mode {
+	for name, value := range proxyModes {
+		if value == mode {
+			return name
+		}
+	}
+
+	return ""
+}
+
+var checkTypes = map[model.CheckType]haproxyv1.HealthCheckType{
+	model.CheckTCP:  haproxyv1.HealthCheckType_HEALTH_CHECK_TYPE_TCP,
+	model.CheckHTTP: haproxyv1.HealthCheckType_HEALTH_CHECK_TYPE_HTTP,
+}
+
+func toHealthCheck(check model.HealthCheck) *haproxyv1.BackendHealthCheck {
+	if check.Type == model.CheckNone {
+		return nil
+	}
+
+	return &haproxyv1.BackendHealthCheck{
+		Type:             checkTypes[check.Type],
+		HttpPath:         check.Path,
+		HttpExpectStatus: check.ExpectStatus,
+		Ssl:              check.SSL,
+		IntervalMs:       check.Interval.Milliseconds(),
+		TimeoutMs:        check.Timeout.Milliseconds(),
+		Rise:             check.Rise,
+		Fall:             check.Fall,
+	}
+}
+
+func fromHealthCheck(check *haproxyv1.BackendHealthCheck) model.HealthCheck {
+	for name, value := range checkTypes {
+		if value == check.GetType() {
+			return model.HealthCheck{
+				Type:         name,
+				Path:         check.GetHttpPath(),
+				ExpectStatus: check.GetHttpExpectStatus(),
+				SSL:          check.GetSsl(),
+				Interval:     time.Duration(check.GetIntervalMs()) * time.Millisecond,
+				Timeout:      time.Duration(check.GetTimeoutMs()) * time.Millisecond,
+				Rise:         check.GetRise(),
+				Fall:         check.GetFall(),
+			}
+		}
+	}
+
+	return model.HealthCheck{}
+}
+
+// stickTableSize is the number of clients remembered for source
+// persistence.
+const stickTableSize = 100000
+
+func toStickTable(persistence time.Duration) *haproxyv1.BackendStickTable {
+	if persistence == 0 {
+		return nil
+	}
+
+	// an ipv6 table also holds IPv4 addresses
+	return &haproxyv1.BackendStickTable{
+		Type:     haproxyv1.StickTableType_STICK_TABLE_TYPE_IPV6,
+		Size:     stickTableSize,
+		ExpireMs: persistence.Milliseconds(),
+		StickOn:  "src",
+	}
+}
+
+// sourceRangesACL matches the clients allowed by the source ranges of a
+// frontend; every other connection is rejected.
+const sourceRangesACL = "haproxy_ccm_source_ranges"
+
+// X-Forwarded-Proto tells the servers the scheme of the client request.
+// HAProxy does not terminate TLS, so it is always "http".
+const (
+	forwardedProtoHeader = "X-Forwarded-Proto"
+	forwardedProto       = "http"
+)
+
+func toFrontend(frontend *model.Frontend) *haproxyv1.Frontend {
+	converted := &haproxyv1.Frontend{
+		Name:                   frontend.Name,
+		Mode:                   toProxyMode(frontend.Mode),
+		DefaultBackend:         frontend.DefaultBackend,
+		HttpRequestTimeoutMs:   frontend.RequestTimeout.Milliseconds(),
+		HttpKeepAliveTimeoutMs: frontend.KeepAliveTimeout.Milliseconds(),
+	}
+
+	if len(frontend.SourceRanges) > 0 {
+		converted.Acls = []*haproxyv1.Acl{{
+			Name:      sourceRangesACL,
+			Criterion: "src",
+			Value:     strings.Join(frontend.SourceRanges, " "),
+		}}
+		converted.TcpRequestRules = []*haproxyv1.TcpRequestRule{{
+			Type:     haproxyv1.TcpRequestType_TCP_REQUEST_TYPE_CONNECTION,
+			Action:   haproxyv1.TcpRequestAction_TCP_REQUEST_ACTION_REJECT,
+			Cond:     "unless",
+			CondTest: sourceRangesACL,
+		}}
+	}
+	if frontend.ForwardedHeaders {
+		converted.Forwardfor = &haproxyv1.Forwardfor{Enabled: true}
+		converted.HttpRequestRules = []*haproxyv1.HttpRequestRule{{
+			Type:      haproxyv1.HttpRequestType_HTTP_REQUEST_TYPE_SET_HEADER,
+			HdrName:   forwardedProtoHeader,
+			HdrFormat: forwardedProto,
+		}}
+	}
+
+	return converted
+}
+
+func fromFrontend(frontend *haproxyv1.Frontend) *model.Frontend {
+	converted := &model.Frontend{
+		Name:             frontend.Name,
+		Mode:             fromProxyMode(frontend.Mode),
+		DefaultBackend:   frontend.DefaultBackend,
+		RequestTimeout:   time.Duration(frontend.HttpRequestTimeoutMs) * time.Millisecond,
+		KeepAliveTimeout: time.Duration(frontend.HttpKeepAliveTimeoutMs) * time.Millisecond,
+		Binds:            map[string]*model.Bind{},
+	}
+	converted.ForwardedHeaders = frontend.Forwardfor.GetEnabled() &&
+		slices.ContainsFunc(frontend.HttpRequestRules, func(rule *haproxyv1.HttpRequestRule) bool {
+			return rule.Type == haproxyv1.HttpRequestType_HTTP_REQUEST_TYPE_SET_HEADER &&
+				rule.HdrName == forwardedProtoHeader && rule.HdrFormat == forwardedProto
+		})
+
+	// the ACL alone does not restrict anything
+	rejecting := slices.ContainsFunc(frontend.TcpRequestRules, func(rule *haproxyv1.TcpRequestRule) bool {
+		return rule.Type == haproxyv1.TcpRequestType_TCP_REQUEST_TYPE_CONNECTION &&
+			rule.Action == haproxyv1.TcpRequestAction_TCP_REQUEST_ACTION_REJECT &&
+			rule.Cond == "unless" && rule.CondTest == sourceRangesACL
+	})
+	for _, acl := range frontend.Acls {
+		if rejecting && acl.Name == sourceRangesACL && acl.Criterion == "src" {
+			converted.SourceRanges = strings.Fields(acl.Value)
+		}
+	}
+
+	return converted
+}
+
+func toBind(bind *model.Bind) *haproxyv1.Bind {
+	return &haproxyv1.Bind{
+		Name:    bind.Name,
+		Address: bind.Address,
+		Port:    bind.Port,
+	}
+}
+
+func fromBind(bind *haproxyv1.Bind) *model.Bind {
+	return &model.Bind{
+		Name:    bind.Name,
+		Address: bind.Address,
+		Port:    bind.Port,
+	}
+}
+
+func toBackend(backend *model.Backend) *haproxyv1.Backend {
+	balance, _ := ParseBalanceAlgorithm(backend.Balance)
+
+	return &haproxyv1.Backend{
+		Name: backend.Name,
+		Mode: toProxyMode(backend.Mode),
+		// validated when the Service is reconciled
+		Balance:         balance,
+		HealthCheck:     toHealthCheck(backend.Check),
+		StickTable:      toStickTable(backend.SourcePersistence),
+		TunnelTimeoutMs: backend.TunnelTimeout.Milliseconds(),
+	}
+}
+
+func fromBackend(backend *haproxyv1.Backend) *model.Backend {
+	return &model.Backend{
+		Name:              backend.Name,
+		Mode:              fromProxyMode(backend.Mode),
+		Balance:           formatBalanceAlgorithm(backend.Balance),
+		Check:             fromHealthCheck(backend.HealthCheck),
+		SourcePersistence: time.Duration(backend.StickTable.GetExpireMs()) * time.Millisecond,
+		TunnelTimeout:     time.Duration(backend.TunnelTimeoutMs) * time.Millisecond,
+		Servers:           map[string]*model.Server{},
+	}
+}
+
+func toServer(server *model.Server) *haproxyv1.Server {
+	var check *haproxyv1.ServerCheck
+	if server.Check {
+		check = &haproxyv1.ServerCheck{Port: server.CheckPort}
+	}
+
+	return &haproxyv1.Server{
+		Name:    server.Name,
+		Address: server.Address,
+		Port:    server.Port,
+		Check:   check,
+		Proto:   server.Proto,
+	}
+}
+
+func fromServer(server *haproxyv1.Server) *model.Server {
+	return &model.Server{
+		Name:      server.Name,
+		Address:   server.Address,
+		Port:      server.Port,
+		Check:     server.Check != nil,
+		CheckPort: server.Check.GetPort(),
+		Proto:     server.Proto,
+	}
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/model"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// backendMode returns the backend mode requested by the annotation of
+// service, or defaultMode without it.
+func backendMode(service *v1.Service, defaultMode model.BackendMode) (model.BackendMode, error) {
+	value, ok := service.Annotations[AnnotationBackendMode]
+	if !ok {
+		return defaultMode, nil
+	}
+
+	switch mode := model.BackendMode(value); mode {
+	case model.BackendNode, model.BackendPod:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("annotation %s: must be %q or %q, got %q", AnnotationBackendMode, model.BackendNode, model.BackendPod, value)
+	}
+}
+
+func (s *ServiceController) endpointSlices(service *v1.Service) ([]*discoveryv1.EndpointSlice, error) {
+	if s.EndpointSlices == nil {
+		return nil, fmt.Errorf("the pod backend mode requires the EndpointSlice informer")
+	}
+
+	return s.EndpointSlices.EndpointSlices(service.Namespace).List(labels.SelectorFromSet(labels.Set{
+		discoveryv1.LabelServiceName: service.Name,
+	}))
+}
+
+// EndpointsWatcher reconciles the load balancers of Services in the pod
+// backend mode when their EndpointSlices change. The service controller
+// only reacts to changes of Services and nodes.
+type EndpointsWatcher struct {
+	LoadBalancer *ServiceController
+	// ClusterName is the --cluster-name of this cluster, overridden by the
+	// ClusterID of LoadBalancer.
+	ClusterName string
+	Services    corelisters.ServiceLister
+
+	synced []cache.InformerSynced
+	queue  workqueue.TypedRateLimitingInterface[string]
+}
+
+func NewEndpointsWatcher(loadBalancer *ServiceController, clusterName string, informerFactory informers.SharedInformerFactory) (*EndpointsWatcher, error) {
+	services := informerFactory.Core().V1().Services()
+	endpointSlices := informerFactory.Discovery().V1().EndpointSlices()
+
+	w := &EndpointsWatcher{
+		LoadBalancer: loadBalancer,
+		ClusterName:  clusterName,
+		Services:     services.Lister(),
+		synced:       []cache.InformerSynced{services.Informer().HasSynced, endpointSlices.Informer().HasSynced},
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "haproxy-endpoints"},
+		),
+	}
+
+	if _, err := endpointSlices.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.enqueue,
+		UpdateFunc: func(_, obj interface{}) { w.enqueue(obj) },
+		DeleteFunc: w.enqueue,
+	}); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Run processes EndpointSlice changes until ctx is done.
+func (w *EndpointsWatcher) Run(ctx context.Context) {
+	defer w.queue.ShutDown()
+
+	if !cache.WaitForCacheSync(ctx.Done(), w.synced...) {
+		return
+	}
+
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for w.processNextItem(ctx) {
+		}
+	}, time.Second)
+
+	<-ctx.Done()
+}
+
+func (w *EndpointsWatcher) enqueue(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+
+	serviceName := slice.Labels[discoveryv1.LabelServiceName]
+	if serviceName == "" {
+		return
+	}
+	w.queue.Add(slice.Namespace + "/" + serviceName)
+}
+
+func (w *EndpointsWatcher) processNextItem(ctx context.Context) bool {
+	key, quit := w.queue.Get()
+	if quit {
+		return false
+	}
+	defer w.queue.Done(key)
+
+	if err := w.sync(ctx, key); err != nil {
+		klog.Errorf("sync endpoints of service %s error: %v", key, err.Error())
+		w.queue.AddRateLimited(key)
+		return true
+	}
+	w.queue.Forget(key)
+
+	return true
+}
+
+func (w *EndpointsWatcher) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	service, err := w.Services.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// load balancers are created and deleted by the service controller,
+	// only existing ones are kept up to date here
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer || service.Spec.LoadBalancerClass != nil ||
+		service.DeletionTimestamp != nil || len(service.Status.LoadBalancer.Ingress) == 0 {
+		return nil
+	}
+	// an invalid annotation is reported by the service controller
+	if mode, err := backendMode(service, w.LoadBalancer.Options.BackendMode); err != nil || mode != model.BackendPod {
+		return nil
+	}
+
+	klog.Infof("Updating HAProxy LoadBalancer %s for changed endpoints...", key)
+	_, err = w.LoadBalancer.reconcileLoadBalancer(ctx, w.LoadBalancer.options(w.ClusterName), service, nil)
+
+	return err
+}
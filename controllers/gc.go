@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"github.com/bear-san/haproxy-ccm/model"
+	"github.com/bear-san/haproxy-ccm/naming"
+	"github.com/bear-san/haproxy-ccm/transaction"
+	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// GarbageCollector deletes HAProxy objects left behind by Services that no
+// longer exist, e.g. because they were deleted while the CCM was down. Only
+// objects named with the prefix of this cluster are deleted, so objects of
+// other clusters sharing the HAProxy and manually created objects are never
+// touched. Orphans with legacy cluster-less names are only reported, as
+// another cluster may own them.
+type GarbageCollector struct {
+	Client       kubernetes.Interface
+	LoadBalancer *ServiceController
+	// ClusterName is the --cluster-name of this cluster, overridden by the
+	// ClusterID of LoadBalancer.
+	ClusterName string
+	Interval    time.Duration
+	// ReportOnly logs orphans instead of deleting them.
+	ReportOnly bool
+}
+
+// Run sweeps once at startup and then every Interval until ctx is done.
+func (g *GarbageCollector) Run(ctx context.Context) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := g.Sweep(ctx); err != nil {
+			klog.Errorf("garbage collect HAProxy resources error: %v", err.Error())
+		}
+	}, g.Interval)
+}
+
+func (g *GarbageCollector) Sweep(ctx context.Context) error {
+	opts := g.LoadBalancer.options(g.ClusterName)
+
+	// HAProxy is listed before the Services, so a Service that creates its
+	// objects in between is always seen as live
+	owners, legacyOwners, err := g.ownerUIDs(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if owners.Len() == 0 && legacyOwners.Len() == 0 {
+		return nil
+	}
+
+	services, err := g.Client.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("list services error: %v", err.Error())
+		return err
+	}
+	live := sets.New[types.UID]()
+	for _, service := range services.Items {
+		if service.Spec.Type == v1.ServiceTypeLoadBalancer {
+			live.Insert(service.UID)
+		}
+	}
+
+	for _, uid := range sets.List(legacyOwners.Difference(live)) {
+		orphan := &v1.Service{ObjectMeta: metav1.ObjectMeta{UID: uid}}
+		klog.Warningf("found orphaned HAProxy resources %s-* named without a cluster, delete them manually if no other cluster owns them", model.LegacyResourcePrefix(orphan, opts))
+	}
+
+	for _, uid := range sets.List(owners.Difference(live)) {
+		orphan := &v1.Service{ObjectMeta: metav1.ObjectMeta{UID: uid}}
+		resourcePrefix := model.ResourcePrefix(orphan, opts)
+
+		if g.ReportOnly {
+			klog.Warningf("found orphaned HAProxy resources %s-*", resourcePrefix)
+			continue
+		}
+
+		klog.Infof("Deleting orphaned HAProxy resources %s-*...", resourcePrefix)
+		if err := g.LoadBalancer.Transactions.Run(ctx, func(ctx context.Context) ([]transaction.Step, error) {
+			// legacy names are not adopted, they may belong to another cluster
+			observed, err := g.LoadBalancer.observeLoadBalancer(ctx, owner{namer: opts.Namer(), uid: uid})
+			if err != nil {
+				return nil, err
+			}
+			return g.LoadBalancer.diffLoadBalancer(model.NewLoadBalancer(), observed), nil
+		}); err != nil {
+			klog.Errorf("delete orphaned HAProxy resources %s-* error: %v", resourcePrefix, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// ownerUIDs returns the Service UIDs found in the names of frontends and
+// backends built by the namer of opts, and those found in legacy names.
+func (g *GarbageCollector) ownerUIDs(ctx context.Context, opts model.Options) (sets.Set[types.UID], sets.Set[types.UID], error) {
+	namer := opts.Namer()
+	owners := sets.New[types.UID]()
+	legacyOwners := sets.New[types.UID]()
+
+	var names []string
+	frontendsResp, err := g.LoadBalancer.HAProxyClient.ListFrontends(ctx, &haproxyv1.ListFrontendsRequest{})
+	if err != nil {
+		klog.Errorf("list frontend error: %v", err.Error())
+		return nil, nil, err
+	}
+	for _, frontend := range frontendsResp.Frontends {
+		names = append(names, frontend.Name)
+	}
+	backendsResp, err := g.LoadBalancer.HAProxyClient.ListBackends(ctx, &haproxyv1.ListBackendsRequest{})
+	if err != nil {
+		klog.Errorf("list backend error: %v", err.Error())
+		return nil, nil, err
+	}
+	for _, backend := range backendsResp.Backends {
+		names = append(names, backend.Name)
+	}
+
+	for _, name := range names {
+		if parsed, ok := namer.Parse(name); ok {
+			owners.Insert(parsed.UID)
+		} else if uid, ok := naming.ParseLegacyUID(opts.NamingPrefix, name); ok {
+			legacyOwners.Insert(uid)
+		}
+	}
+
+	return owners, legacyOwners, nil
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/model"
+	v1 "k8s.io/api/core/v1"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serviceHealthCheck applies the health check annotations of service to
+// defaults. Every invalid annotation is reported at once.
+func serviceHealthCheck(service *v1.Service, defaults model.HealthCheck) (model.HealthCheck, error) {
+	check := defaults
+	var errs []error
+
+	annotation := func(name string, parse func(value string) error) {
+		value, ok := service.Annotations[name]
+		if !ok {
+			return
+		}
+		if err := parse(strings.TrimSpace(value)); err != nil {
+			errs = append(errs, fmt.Errorf("annotation %s: %w", name, err))
+		}
+	}
+
+	annotation(AnnotationHealthCheckType, func(value string) (err error) {
+		check.Type, err = model.ParseCheckType(value)
+		return err
+	})
+	annotation(AnnotationHealthCheckPath, func(value string) error {
+		if !strings.HasPrefix(value, "/") {
+			return fmt.Errorf("must start with \"/\", got %q", value)
+		}
+		check.Path = value
+		return nil
+	})
+	annotation(AnnotationHealthCheckExpectedStatus, func(value string) (err error) {
+		check.ExpectStatus, err = parseStatus(value)
+		return err
+	})
+	annotation(AnnotationHealthCheckSSL, func(value string) (err error) {
+		check.SSL, err = strconv.ParseBool(value)
+		return err
+	})
+	annotation(AnnotationHealthCheckInterval, func(value string) (err error) {
+		check.Interval, err = parsePositiveDuration(value)
+		return err
+	})
+	annotation(AnnotationHealthCheckTimeout, func(value string) (err error) {
+		check.Timeout, err = parsePositiveDuration(value)
+		return err
+	})
+	annotation(AnnotationHealthCheckRise, func(value string) (err error) {
+		check.Rise, err = parseCount(value)
+		return err
+	})
+	annotation(AnnotationHealthCheckFall, func(value string) (err error) {
+		check.Fall, err = parseCount(value)
+		return err
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return model.HealthCheck{}, err
+	}
+
+	return check, nil
+}
+
+func parseStatus(value string) (int32, error) {
+	status, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || status < 100 || status > 599 {
+		return 0, fmt.Errorf("must be an HTTP status code, got %q", value)
+	}
+
+	return int32(status), nil
+}
+
+func parsePositiveDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("must be positive, got %s", duration)
+	}
+
+	return duration, nil
+}
+
+func parseCount(value string) (int32, error) {
+	count, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("must be a positive number, got %q", value)
+	}
+
+	return int32(count), nil
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"strconv"
+	"strings"
+)
+
+// httpPorts returns the numbers of the ports listed by the HTTP ports
+// annotation of service. Every entry must name a TCP port of service.
+func httpPorts(service *v1.Service) ([]int32, error) {
+	value, ok := service.Annotations[AnnotationHTTPPorts]
+	if !ok {
+		return nil, nil
+	}
+
+	var ports []int32
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		found := false
+		for _, port := range service.Spec.Ports {
+			if port.Protocol == v1.ProtocolTCP && (port.Name == entry || strconv.Itoa(int(port.Port)) == entry) {
+				ports = append(ports, port.Port)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("annotation %s: no TCP port %q", AnnotationHTTPPorts, entry)
+		}
+	}
+
+	return ports, nil
+}
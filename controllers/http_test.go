@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"slices"
+	"testing"
+)
+
+func TestHTTPPorts(t *testing.T) {
+	ports := []v1.ServicePort{
+		{Name: "web", Protocol: v1.ProtocolTCP, Port: 80},
+		{Name: "admin", Protocol: v1.ProtocolTCP, Port: 8080},
+		{Name: "dns", Protocol: v1.ProtocolUDP, Port: 53},
+	}
+
+	for _, tt := range []struct {
+		name       string
+		annotation *string
+		want       []int32
+		wantErr    bool
+	}{
+		{name: "unset"},
+		{name: "empty", annotation: ptr.To("")},
+		{name: "by name", annotation: ptr.To("web"), want: []int32{80}},
+		{name: "by number", annotation: ptr.To("8080"), want: []int32{8080}},
+		{name: "list", annotation: ptr.To(" web, 8080 ,"), want: []int32{80, 8080}},
+		{name: "unknown port", annotation: ptr.To("web,443"), wantErr: true},
+		{name: "udp port", annotation: ptr.To("dns"), wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{Spec: v1.ServiceSpec{Ports: ports}}
+			if tt.annotation != nil {
+				service.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{AnnotationHTTPPorts: *tt.annotation}}
+			}
+
+			got, err := httpPorts(service)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("httpPorts() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("httpPorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
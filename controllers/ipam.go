@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/ipam"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"net/netip"
+	"strings"
+)
+
+// loadBalancerAddresses returns the VIPs the Service's frontends bind to.
+// Requested addresses win over spec.externalIPs, and Services with neither
+// get an address from the IP pools. Without pools, requested addresses are
+// ignored in favour of spec.externalIPs.
+func (s *ServiceController) loadBalancerAddresses(service *v1.Service) ([]string, error) {
+	owner := allocationOwner(service)
+
+	requested, err := requestedAddresses(service)
+	if s.IPAM == nil && len(service.Spec.ExternalIPs) > 0 {
+		if err != nil || len(requested) > 0 {
+			klog.Warningf("service %s requests addresses but no IP pool is configured, ignoring spec.loadBalancerIP and the %s annotation in favour of spec.externalIPs", owner, AnnotationLoadBalancerIPs)
+		}
+		return service.Spec.ExternalIPs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(requested) > 0 {
+		if s.IPAM == nil {
+			return nil, fmt.Errorf("service %s requests addresses but no IP pool is configured", owner)
+		}
+		if err := s.IPAM.Assign(owner, requested); err != nil {
+			return nil, fmt.Errorf("assign requested address to service %s: %w", owner, err)
+		}
+
+		addresses := make([]string, 0, len(requested))
+		for _, addr := range requested {
+			addresses = append(addresses, addr.String())
+		}
+		return addresses, nil
+	}
+
+	if len(service.Spec.ExternalIPs) > 0 {
+		if s.IPAM != nil {
+			s.IPAM.Release(owner)
+		}
+		return service.Spec.ExternalIPs, nil
+	}
+
+	if s.IPAM == nil {
+		return nil, fmt.Errorf("service %s has no externalIPs and no IP pool is configured", owner)
+	}
+
+	// keep the address already reported in the status
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		addr, err := netip.ParseAddr(ingress.IP)
+		if err != nil || !s.IPAM.Contains(addr) {
+			continue
+		}
+		if err := s.IPAM.Reserve(owner, addr); err != nil {
+			klog.Warningf("keep load balancer address of %s error: %v", owner, err.Error())
+		}
+	}
+
+	addr, err := s.IPAM.Allocate(owner)
+	if err != nil {
+		return nil, fmt.Errorf("allocate address for service %s: %w", owner, err)
+	}
+
+	return []string{addr.String()}, nil
+}
+
+// requestedAddresses returns the VIPs asked for through the annotation or
+// spec.loadBalancerIP.
+func requestedAddresses(service *v1.Service) ([]netip.Addr, error) {
+	requested := service.Spec.LoadBalancerIP
+	if value, ok := service.Annotations[AnnotationLoadBalancerIPs]; ok {
+		requested = value
+	}
+
+	var addrs []netip.Addr
+	for _, item := range strings.Split(requested, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid requested address %q for service %s/%s: %w", item, service.Namespace, service.Name, err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
+
+func allocationOwner(service *v1.Service) string {
+	return fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+}
+
+// restoreAllocations rebuilds the allocator state from the addresses already
+// reported by LoadBalancer Services, so no address is handed out twice
+// after a restart.
+func restoreAllocations(ctx context.Context, client kubernetes.Interface, allocator *ipam.Allocator) error {
+	services, err := client.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, service := range services.Items {
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+
+		addrs, _ := requestedAddresses(&service)
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if addr, err := netip.ParseAddr(ingress.IP); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+
+		for _, addr := range addrs {
+			if !allocator.Contains(addr) {
+				continue
+			}
+			if err := allocator.Reserve(allocationOwner(&service), addr); err != nil {
+				klog.Warningf("restore address of %s/%s error: %v", service.Namespace, service.Name, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"github.com/bear-san/haproxy-ccm/ipam"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"slices"
+	"testing"
+)
+
+func TestLoadBalancerAddresses(t *testing.T) {
+	pool, err := ipam.ParsePool("192.0.2.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name           string
+		pools          []*ipam.Pool
+		externalIPs    []string
+		loadBalancerIP string
+		annotation     string
+		want           []string
+		wantErr        bool
+	}{
+		{
+			name:        "externalIPs without pools",
+			externalIPs: []string{"198.51.100.1"},
+			want:        []string{"198.51.100.1"},
+		},
+		{
+			name:           "loadBalancerIP ignored without pools",
+			externalIPs:    []string{"198.51.100.1"},
+			loadBalancerIP: "192.0.2.1",
+			want:           []string{"198.51.100.1"},
+		},
+		{
+			name:        "annotation ignored without pools",
+			externalIPs: []string{"198.51.100.1"},
+			annotation:  "192.0.2.1",
+			want:        []string{"198.51.100.1"},
+		},
+		{
+			name:           "invalid loadBalancerIP ignored without pools",
+			externalIPs:    []string{"198.51.100.1"},
+			loadBalancerIP: "not-an-ip",
+			want:           []string{"198.51.100.1"},
+		},
+		{
+			name:           "loadBalancerIP without pools or externalIPs",
+			loadBalancerIP: "192.0.2.1",
+			wantErr:        true,
+		},
+		{
+			name:    "nothing without pools",
+			wantErr: true,
+		},
+		{
+			name:           "loadBalancerIP wins with pools",
+			pools:          []*ipam.Pool{pool},
+			externalIPs:    []string{"198.51.100.1"},
+			loadBalancerIP: "192.0.2.2",
+			want:           []string{"192.0.2.2"},
+		},
+		{
+			name:           "loadBalancerIP outside the pools",
+			pools:          []*ipam.Pool{pool},
+			loadBalancerIP: "198.51.100.1",
+			wantErr:        true,
+		},
+		{
+			name:        "externalIPs with pools",
+			pools:       []*ipam.Pool{pool},
+			externalIPs: []string{"198.51.100.1"},
+			want:        []string{"198.51.100.1"},
+		},
+		{
+			name:  "allocated from the pools",
+			pools: []*ipam.Pool{pool},
+			want:  []string{"192.0.2.1"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &ServiceController{}
+			if tt.pools != nil {
+				s.IPAM = ipam.NewAllocator(tt.pools)
+			}
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+				Spec: v1.ServiceSpec{
+					Type:           v1.ServiceTypeLoadBalancer,
+					ExternalIPs:    tt.externalIPs,
+					LoadBalancerIP: tt.loadBalancerIP,
+				},
+			}
+			if tt.annotation != "" {
+				service.Annotations = map[string]string{AnnotationLoadBalancerIPs: tt.annotation}
+			}
+
+			got, err := s.loadBalancerAddresses(service)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadBalancerAddresses() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("loadBalancerAddresses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
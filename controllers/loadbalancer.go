@@ -0,0 +1,349 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/model"
+	"github.com/bear-san/haproxy-ccm/transaction"
+	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
+	"k8s.io/klog/v2"
+	"maps"
+	"slices"
+)
+
+// observeLoadBalancer reads the objects of owner from the running
+// configuration. Objects it does not own are left out, so they are never
+// part of a diff.
+func (s *ServiceController) observeLoadBalancer(ctx context.Context, owner owner) (*model.LoadBalancer, error) {
+	observed := model.NewLoadBalancer()
+
+	backendsResp, err := s.HAProxyClient.ListBackends(ctx, &haproxyv1.ListBackendsRequest{})
+	if err != nil {
+		klog.Errorf("list backend error: %v", err.Error())
+		return nil, err
+	}
+	for _, backend := range backendsResp.Backends {
+		if !owner.ownsProxy(backend.Name) {
+			continue
+		}
+
+		serversResp, err := s.HAProxyClient.ListServers(ctx, &haproxyv1.ListServersRequest{
+			BackendName: backend.Name,
+		})
+		if err != nil {
+			klog.Errorf("list server error: %v", err.Error())
+			return nil, err
+		}
+
+		observedBackend := fromBackend(backend)
+		for _, server := range serversResp.Servers {
+			if !owner.ownsServer(backend.Name, server.Name) {
+				observedBackend.Unmanaged = append(observedBackend.Unmanaged, server.Name)
+				continue
+			}
+			observedBackend.Servers[server.Name] = fromServer(server)
+		}
+		observed.Backends[backend.Name] = observedBackend
+	}
+
+	frontendsResp, err := s.HAProxyClient.ListFrontends(ctx, &haproxyv1.ListFrontendsRequest{})
+	if err != nil {
+		klog.Errorf("list frontend error: %v", err.Error())
+		return nil, err
+	}
+	for _, frontend := range frontendsResp.Frontends {
+		if !owner.ownsProxy(frontend.Name) {
+			continue
+		}
+
+		bindsResp, err := s.HAProxyClient.ListBinds(ctx, &haproxyv1.ListBindsRequest{
+			FrontendName: frontend.Name,
+		})
+		if err != nil {
+			klog.Errorf("list bind error: %v", err.Error())
+			return nil, err
+		}
+
+		observedFrontend := fromFrontend(frontend)
+		for _, bind := range bindsResp.Binds {
+			if !owner.ownsBind(frontend.Name, bind.Name) {
+				observedFrontend.Unmanaged = append(observedFrontend.Unmanaged, bind.Name)
+				continue
+			}
+			observedFrontend.Binds[bind.Name] = fromBind(bind)
+		}
+		observed.Frontends[frontend.Name] = observedFrontend
+	}
+
+	return observed, nil
+}
+
+// diffLoadBalancer returns the steps that turn observed into desired.
+// Backends are created before the frontends that use them and deleted after.
+// Frontends and backends still holding unmanaged objects are kept.
+func (s *ServiceController) diffLoadBalancer(desired, observed *model.LoadBalancer) []transaction.Step {
+	var steps []transaction.Step
+
+	for _, name := range slices.Sorted(maps.Keys(desired.Backends)) {
+		want := desired.Backends[name]
+		have, ok := observed.Backends[name]
+		if !ok {
+			steps = append(steps, s.createBackend(want))
+			have = &model.Backend{Servers: map[string]*model.Server{}}
+		} else if !want.SameSettings(have) {
+			steps = append(steps, s.updateBackend(want))
+		}
+
+		for _, serverName := range slices.Sorted(maps.Keys(want.Servers)) {
+			server := want.Servers[serverName]
+			current, ok := have.Servers[serverName]
+			if !ok {
+				steps = append(steps, s.createServer(name, server))
+			} else if *server != *current {
+				steps = append(steps, s.updateServer(name, server))
+			}
+		}
+		for _, serverName := range slices.Sorted(maps.Keys(have.Servers)) {
+			if _, ok := want.Servers[serverName]; !ok {
+				steps = append(steps, s.deleteServer(name, serverName))
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(desired.Frontends)) {
+		want := desired.Frontends[name]
+		have, ok := observed.Frontends[name]
+		if !ok {
+			steps = append(steps, s.createFrontend(want))
+			have = &model.Frontend{Binds: map[string]*model.Bind{}}
+		} else if !want.SameSettings(have) {
+			steps = append(steps, s.updateFrontend(want))
+		}
+
+		for _, bindName := range slices.Sorted(maps.Keys(want.Binds)) {
+			bind := want.Binds[bindName]
+			current, ok := have.Binds[bindName]
+			if !ok {
+				steps = append(steps, s.createBind(name, bind))
+			} else if *bind != *current {
+				steps = append(steps, s.updateBind(name, bind))
+			}
+		}
+		for _, bindName := range slices.Sorted(maps.Keys(have.Binds)) {
+			if _, ok := want.Binds[bindName]; !ok {
+				steps = append(steps, s.deleteBind(name, bindName))
+			}
+		}
+	}
+
+	// delete obsolete frontends before the backends they point to
+	kept := map[string]bool{}
+	for _, name := range slices.Sorted(maps.Keys(observed.Frontends)) {
+		if _, ok := desired.Frontends[name]; ok {
+			continue
+		}
+		for _, bindName := range slices.Sorted(maps.Keys(observed.Frontends[name].Binds)) {
+			steps = append(steps, s.deleteBind(name, bindName))
+		}
+		if unmanaged := observed.Frontends[name].Unmanaged; len(unmanaged) > 0 {
+			klog.Warningf("keeping frontend %s, it holds binds not managed by haproxy-ccm: %v", name, unmanaged)
+			kept[observed.Frontends[name].DefaultBackend] = true
+			continue
+		}
+		steps = append(steps, s.deleteFrontend(name))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(observed.Backends)) {
+		if _, ok := desired.Backends[name]; ok {
+			continue
+		}
+		for _, serverName := range slices.Sorted(maps.Keys(observed.Backends[name].Servers)) {
+			steps = append(steps, s.deleteServer(name, serverName))
+		}
+		if unmanaged := observed.Backends[name].Unmanaged; len(unmanaged) > 0 {
+			klog.Warningf("keeping backend %s, it holds servers not managed by haproxy-ccm: %v", name, unmanaged)
+			continue
+		}
+		if kept[name] {
+			klog.Warningf("keeping backend %s, a kept frontend still uses it", name)
+			continue
+		}
+		steps = append(steps, s.deleteBackend(name))
+	}
+
+	return steps
+}
+
+func (s *ServiceController) createBackend(backend *model.Backend) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("create backend %s", backend.Name),
+		Group: backend.Name,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.CreateBackend(ctx, &haproxyv1.CreateBackendRequest{
+				Backend:       toBackend(backend),
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) updateBackend(backend *model.Backend) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("update backend %s", backend.Name),
+		Group: backend.Name,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.UpdateBackend(ctx, &haproxyv1.UpdateBackendRequest{
+				Backend:       toBackend(backend),
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) deleteBackend(name string) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("delete backend %s", name),
+		Group: name,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.DeleteBackend(ctx, &haproxyv1.DeleteBackendRequest{
+				Name:          name,
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) createServer(backendName string, server *model.Server) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("create server %s/%s", backendName, server.Name),
+		Group: backendName,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.CreateServer(ctx, &haproxyv1.CreateServerRequest{
+				Server:        toServer(server),
+				BackendName:   backendName,
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) updateServer(backendName string, server *model.Server) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("update server %s/%s", backendName, server.Name),
+		Group: backendName,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.UpdateServer(ctx, &haproxyv1.UpdateServerRequest{
+				Server:        toServer(server),
+				BackendName:   backendName,
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) deleteServer(backendName string, name string) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("delete server %s/%s", backendName, name),
+		Group: backendName,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.DeleteServer(ctx, &haproxyv1.DeleteServerRequest{
+				Name:          name,
+				BackendName:   backendName,
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) createFrontend(frontend *model.Frontend) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("create frontend %s", frontend.Name),
+		Group: frontend.Name,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.CreateFrontend(ctx, &haproxyv1.CreateFrontendRequest{
+				Frontend:      toFrontend(frontend),
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) updateFrontend(frontend *model.Frontend) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("update frontend %s", frontend.Name),
+		Group: frontend.Name,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.UpdateFrontend(ctx, &haproxyv1.UpdateFrontendRequest{
+				Frontend:      toFrontend(frontend),
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) deleteFrontend(name string) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("delete frontend %s", name),
+		Group: name,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.DeleteFrontend(ctx, &haproxyv1.DeleteFrontendRequest{
+				Name:          name,
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) createBind(frontendName string, bind *model.Bind) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("create bind %s/%s", frontendName, bind.Name),
+		Group: frontendName,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.CreateBind(ctx, &haproxyv1.CreateBindRequest{
+				Bind:          toBind(bind),
+				FrontendName:  frontendName,
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) updateBind(frontendName string, bind *model.Bind) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("update bind %s/%s", frontendName, bind.Name),
+		Group: frontendName,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.UpdateBind(ctx, &haproxyv1.UpdateBindRequest{
+				Bind:          toBind(bind),
+				FrontendName:  frontendName,
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
+
+func (s *ServiceController) deleteBind(frontendName string, name string) transaction.Step {
+	return transaction.Step{
+		Name:  fmt.Sprintf("delete bind %s/%s", frontendName, name),
+		Group: frontendName,
+		Run: func(ctx context.Context, transactionID string) error {
+			_, err := s.HAProxyClient.DeleteBind(ctx, &haproxyv1.DeleteBindRequest{
+				Name:          name,
+				FrontendName:  frontendName,
+				TransactionId: transactionID,
+			})
+			return err
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"github.com/bear-san/haproxy-ccm/model"
+	"github.com/bear-san/haproxy-ccm/naming"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+)
+
+// owner decides which HAProxy objects belong to one Service. Names are
+// parsed instead of prefix matched, so objects of other Services and
+// manually created objects are never updated or deleted, even inside a
+// frontend or backend of the Service.
+type owner struct {
+	namer *naming.Namer
+	uid   types.UID
+	// legacyPrefix adopts objects named before names were scoped to a
+	// cluster, so that they are replaced instead of left behind. Empty
+	// disables adoption.
+	legacyPrefix string
+}
+
+// serviceOwner owns the objects of service, including legacy ones.
+func serviceOwner(service *v1.Service, opts model.Options) owner {
+	return owner{
+		namer:        opts.Namer(),
+		uid:          service.UID,
+		legacyPrefix: model.LegacyResourcePrefix(service, opts) + "-",
+	}
+}
+
+// ownsProxy reports whether the frontend or backend name belongs to the
+// Service.
+func (o owner) ownsProxy(name string) bool {
+	if parsed, ok := o.namer.Parse(name); ok {
+		return parsed.UID == o.uid && parsed.Address == "" && parsed.Server == ""
+	}
+
+	return o.legacyPrefix != "" && strings.HasPrefix(name, o.legacyPrefix)
+}
+
+// ownsBind reports whether the bind name of an owned frontend belongs to the
+// Service.
+func (o owner) ownsBind(frontendName string, name string) bool {
+	if parsed, ok := o.namer.Parse(name); ok {
+		return parsed.UID == o.uid && parsed.Address != ""
+	}
+
+	return o.legacyPrefix != "" && strings.HasPrefix(frontendName, o.legacyPrefix) && strings.HasPrefix(name, o.legacyPrefix)
+}
+
+// ownsServer reports whether the server name of an owned backend belongs to
+// the Service.
+func (o owner) ownsServer(backendName string, name string) bool {
+	if parsed, ok := o.namer.Parse(name); ok {
+		return parsed.UID == o.uid && parsed.Server != ""
+	}
+
+	return o.legacyPrefix != "" && strings.HasPrefix(backendName, o.legacyPrefix) && strings.HasPrefix(name, "server-"+string(o.uid)+"-")
+}
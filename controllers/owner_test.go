@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"github.com/bear-san/haproxy-ccm/model"
+	"github.com/bear-san/haproxy-ccm/naming"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"slices"
+	"testing"
+)
+
+const (
+	testUID  types.UID = "0f0e0d0c-1111-2222-3333-444455556666"
+	otherUID types.UID = "0f0e0d0c-1111-2222-3333-777777777777"
+)
+
+var testPort = v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80}
+
+func testOwner() owner {
+	return owner{
+		namer:        naming.New("haproxy", "k"),
+		uid:          testUID,
+		legacyPrefix: "haproxy-" + string(testUID) + "-",
+	}
+}
+
+func TestOwnsProxy(t *testing.T) {
+	o := testOwner()
+	namer := o.namer
+
+	for _, tt := range []struct {
+		name      string
+		proxyName string
+		want      bool
+	}{
+		{"own port", namer.Port(testUID, testPort), true},
+		{"own udp port", namer.Port(testUID, v1.ServicePort{Protocol: v1.ProtocolUDP, Port: 53}), true},
+		{"bind name", namer.Bind(testUID, testPort, "192.0.2.1"), false},
+		{"server name", namer.Server(testUID, testPort, "node-1"), false},
+		{"other service", namer.Port(otherUID, testPort), false},
+		{"other cluster", naming.New("haproxy", "other").Port(testUID, testPort), false},
+		{"other naming prefix", naming.New("lb", "k").Port(testUID, testPort), false},
+		{"legacy", "haproxy-" + string(testUID) + "-tcp-80", true},
+		{"legacy of other service", "haproxy-" + string(otherUID) + "-tcp-80", false},
+		{"service prefix without separator", "haproxy-" + string(testUID), false},
+		{"cluster prefix without port", "haproxy-k-" + string(testUID), false},
+		{"custom suffix", "haproxy-k-" + string(testUID) + "-custom", false},
+		{"longer uid", "haproxy-k-" + string(testUID) + "7-tcp-80", false},
+		{"unparsable port", "haproxy-k-" + string(testUID) + "-tcp-080", false},
+		{"unrelated", "stats", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := o.ownsProxy(tt.proxyName); got != tt.want {
+				t.Errorf("ownsProxy(%q) = %v, want %v", tt.proxyName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnsProxyWithoutLegacyAdoption(t *testing.T) {
+	o := testOwner()
+	o.legacyPrefix = ""
+
+	if name := "haproxy-" + string(testUID) + "-tcp-80"; o.ownsProxy(name) {
+		t.Errorf("ownsProxy(%q) = true without legacy adoption", name)
+	}
+}
+
+func TestOwnsBind(t *testing.T) {
+	o := testOwner()
+	namer := o.namer
+	frontend := namer.Port(testUID, testPort)
+	legacyFrontend := "haproxy-" + string(testUID) + "-tcp-80"
+
+	for _, tt := range []struct {
+		name     string
+		frontend string
+		bindName string
+		want     bool
+	}{
+		{"own ipv4 bind", frontend, namer.Bind(testUID, testPort, "192.0.2.1"), true},
+		{"own ipv6 bind", frontend, namer.Bind(testUID, testPort, "2001:db8::1"), true},
+		{"frontend name", frontend, frontend, false},
+		{"server name", frontend, namer.Server(testUID, testPort, "node-1"), false},
+		{"bind of other service", frontend, namer.Bind(otherUID, testPort, "192.0.2.1"), false},
+		{"bind of other cluster", frontend, naming.New("haproxy", "other").Bind(testUID, testPort, "192.0.2.1"), false},
+		{"manual", frontend, "manual", false},
+		{"manual with service prefix", frontend, "haproxy-k-" + string(testUID) + "-tcp-80-extra", false},
+		{"legacy bind", legacyFrontend, legacyFrontend + "-192.0.2.1", true},
+		{"legacy bind in new frontend", frontend, legacyFrontend + "-192.0.2.1", false},
+		{"manual in legacy frontend", legacyFrontend, "manual", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := o.ownsBind(tt.frontend, tt.bindName); got != tt.want {
+				t.Errorf("ownsBind(%q, %q) = %v, want %v", tt.frontend, tt.bindName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnsServer(t *testing.T) {
+	o := testOwner()
+	namer := o.namer
+	backend := namer.Port(testUID, testPort)
+	legacyBackend := "haproxy-" + string(testUID) + "-tcp-80"
+
+	for _, tt := range []struct {
+		name       string
+		backend    string
+		serverName string
+		want       bool
+	}{
+		{"own server", backend, namer.Server(testUID, testPort, "node-1"), true},
+		{"own pod server", backend, namer.Server(testUID, testPort, "10.244.0.5"), true},
+		{"backend name", backend, backend, false},
+		{"bind name", backend, namer.Bind(testUID, testPort, "192.0.2.1"), false},
+		{"server of other service", backend, namer.Server(otherUID, testPort, "node-1"), false},
+		{"server of other cluster", backend, naming.New("haproxy", "other").Server(testUID, testPort, "node-1"), false},
+		{"manual", backend, "manual", false},
+		{"legacy server", legacyBackend, "server-" + string(testUID) + "-node-1", true},
+		{"legacy server of other service", legacyBackend, "server-" + string(otherUID) + "-node-1", false},
+		{"legacy server in new backend", backend, "server-" + string(testUID) + "-node-1", false},
+		{"legacy server without separator", legacyBackend, "server-" + string(testUID), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := o.ownsServer(tt.backend, tt.serverName); got != tt.want {
+				t.Errorf("ownsServer(%q, %q) = %v, want %v", tt.backend, tt.serverName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLoadBalancerKeepsUnmanaged(t *testing.T) {
+	s := &ServiceController{}
+
+	for _, tt := range []struct {
+		name     string
+		desired  func() *model.LoadBalancer
+		observed func() *model.LoadBalancer
+		want     []string
+	}{
+		{
+			name:     "unchanged with unmanaged objects",
+			desired:  testLoadBalancer,
+			observed: withUnmanaged(testLoadBalancer),
+			want:     nil,
+		},
+		{
+			name:     "deleted with unmanaged objects",
+			desired:  model.NewLoadBalancer,
+			observed: withUnmanaged(testLoadBalancer),
+			want: []string{
+				"delete bind fe/fe-192.0.2.1",
+				"delete server fe/fe-srv-node-1",
+			},
+		},
+		{
+			name:    "deleted with unmanaged bind only",
+			desired: model.NewLoadBalancer,
+			observed: func() *model.LoadBalancer {
+				lb := testLoadBalancer()
+				lb.Frontends["fe"].Unmanaged = []string{"manual"}
+				return lb
+			},
+			// the backend is still used by the kept frontend
+			want: []string{
+				"delete bind fe/fe-192.0.2.1",
+				"delete server fe/fe-srv-node-1",
+			},
+		},
+		{
+			name:    "deleted with unmanaged server only",
+			desired: model.NewLoadBalancer,
+			observed: func() *model.LoadBalancer {
+				lb := testLoadBalancer()
+				lb.Backends["fe"].Unmanaged = []string{"manual"}
+				return lb
+			},
+			want: []string{
+				"delete bind fe/fe-192.0.2.1",
+				"delete frontend fe",
+				"delete server fe/fe-srv-node-1",
+			},
+		},
+		{
+			name:     "deleted without unmanaged objects",
+			desired:  model.NewLoadBalancer,
+			observed: testLoadBalancer,
+			want: []string{
+				"delete bind fe/fe-192.0.2.1",
+				"delete frontend fe",
+				"delete server fe/fe-srv-node-1",
+				"delete backend fe",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, step := range s.diffLoadBalancer(tt.desired(), tt.observed()) {
+				got = append(got, step.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("diffLoadBalancer() steps = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testLoadBalancer returns one port with one bind and one server.
+func testLoadBalancer() *model.LoadBalancer {
+	lb := model.NewLoadBalancer()
+	lb.Backends["fe"] = &model.Backend{
+		Name: "fe",
+		Mode: model.ModeTCP,
+		Servers: map[string]*model.Server{
+			"fe-srv-node-1": {Name: "fe-srv-node-1", Address: "10.0.0.1", Port: 30080},
+		},
+	}
+	lb.Frontends["fe"] = &model.Frontend{
+		Name:           "fe",
+		Mode:           model.ModeTCP,
+		DefaultBackend: "fe",
+		Binds: map[string]*model.Bind{
+			"fe-192.0.2.1": {Name: "fe-192.0.2.1", Address: "192.0.2.1", Port: 80},
+		},
+	}
+
+	return lb
+}
+
+func withUnmanaged(build func() *model.LoadBalancer) func() *model.LoadBalancer {
+	return func() *model.LoadBalancer {
+		lb := build()
+		lb.Frontends["fe"].Unmanaged = []string{"manual"}
+		lb.Backends["fe"].Unmanaged = []string{"manual"}
+		return lb
+	}
+}
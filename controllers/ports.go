@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/model"
+	"github.com/bear-san/haproxy-ccm/naming"
+	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Reasons set as PortStatus.Error of ports that are not load balanced.
+const (
+	PortErrorUnsupportedProtocol = "haproxy-ccm/UnsupportedProtocol"
+	PortErrorPortConflict        = "haproxy-ccm/PortConflict"
+	PortErrorInvalidAnnotation   = "haproxy-ccm/InvalidAnnotation"
+)
+
+// portKey identifies a port of a Service, the protocol and port number are
+// unique within a Service.
+type portKey struct {
+	Protocol v1.Protocol
+	Port     int32
+}
+
+func keyOf(port v1.ServicePort) portKey {
+	return portKey{Protocol: port.Protocol, Port: port.Port}
+}
+
+// portError tells why a port is not load balanced. Reason is one of the
+// PortError constants.
+type portError struct {
+	Reason  string
+	Message string
+}
+
+// portErrors holds the ports of one Service that are not load balanced. The
+// objects of these ports are left as they are in HAProxy.
+type portErrors map[portKey]portError
+
+// unsupportedPorts returns the ports of service whose protocol cannot be
+// load balanced.
+func unsupportedPorts(service *v1.Service) portErrors {
+	errs := portErrors{}
+	for _, port := range service.Spec.Ports {
+		if !model.SupportsProtocol(port.Protocol) {
+			errs[keyOf(port)] = portError{
+				Reason:  PortErrorUnsupportedProtocol,
+				Message: fmt.Sprintf("HAProxy does not proxy %s", port.Protocol),
+			}
+		}
+	}
+
+	return errs
+}
+
+// rejectUnserved sets reason for every port of service that has no error
+// yet and is not served on any of addresses.
+func (e portErrors) rejectUnserved(service *v1.Service, addresses []string, served func(address string, port v1.ServicePort) bool, reason string, message string) {
+	for _, port := range service.Spec.Ports {
+		if _, ok := e[keyOf(port)]; ok {
+			continue
+		}
+		if !slices.ContainsFunc(addresses, func(address string) bool { return served(address, port) }) {
+			e[keyOf(port)] = portError{Reason: reason, Message: message}
+		}
+	}
+}
+
+// reject sets reason for the port of the frontend or backend name.
+func (e portErrors) reject(namer *naming.Namer, name string, reason string, message string) {
+	if parsed, ok := namer.Parse(name); ok {
+		e[portKey{Protocol: parsed.Protocol, Port: parsed.Port}] = portError{Reason: reason, Message: message}
+	}
+}
+
+// names returns the names of the frontends and backends of the ports.
+func (e portErrors) names(namer *naming.Namer, uid types.UID) sets.Set[string] {
+	names := sets.New[string]()
+	for key := range e {
+		names.Insert(namer.Port(uid, v1.ServicePort{Protocol: key.Protocol, Port: key.Port}))
+	}
+
+	return names
+}
+
+// warnPortErrors records a warning event for every port that is not load
+// balanced.
+func (s *ServiceController) warnPortErrors(service *v1.Service, errs portErrors) {
+	for _, port := range service.Spec.Ports {
+		if portErr, ok := errs[keyOf(port)]; ok {
+			reason := strings.TrimPrefix(portErr.Reason, "haproxy-ccm/")
+			s.event(service, v1.EventTypeWarning, reason, "Port %d/%s is not load balanced: %s", port.Port, port.Protocol, portErr.Message)
+		}
+	}
+}
+
+func (s *ServiceController) event(service *v1.Service, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if s.Recorder == nil {
+		return
+	}
+	s.Recorder.Eventf(service, eventType, reason, messageFmt, args...)
+}
+
+// keepObserved returns desired with the frontends and backends in names
+// replaced by their observed state, so that the diff leaves them as they are.
+func keepObserved(desired, observed *model.LoadBalancer, names sets.Set[string]) *model.LoadBalancer {
+	lb := &model.LoadBalancer{
+		Frontends: maps.Clone(desired.Frontends),
+		Backends:  maps.Clone(desired.Backends),
+	}
+
+	for name := range names {
+		delete(lb.Frontends, name)
+		if frontend, ok := observed.Frontends[name]; ok {
+			lb.Frontends[name] = frontend
+		}
+		delete(lb.Backends, name)
+		if backend, ok := observed.Backends[name]; ok {
+			lb.Backends[name] = backend
+		}
+	}
+
+	return lb
+}
+
+// portConflicts returns the ports whose new binds would take an address and
+// port already bound by a frontend that owner does not own. Nothing is
+// listed when desired adds no bind.
+func (s *ServiceController) portConflicts(ctx context.Context, owner owner, desired, observed *model.LoadBalancer) (portErrors, error) {
+	added := map[string][]*model.Bind{}
+	for _, name := range slices.Sorted(maps.Keys(desired.Frontends)) {
+		for _, bindName := range slices.Sorted(maps.Keys(desired.Frontends[name].Binds)) {
+			if have, ok := observed.Frontends[name]; ok && have.Binds[bindName] != nil {
+				continue
+			}
+			added[name] = append(added[name], desired.Frontends[name].Binds[bindName])
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	frontendsResp, err := s.HAProxyClient.ListFrontends(ctx, &haproxyv1.ListFrontendsRequest{})
+	if err != nil {
+		klog.Errorf("list frontend error: %v", err.Error())
+		return nil, err
+	}
+
+	conflicts := portErrors{}
+	for _, frontend := range frontendsResp.Frontends {
+		if owner.ownsProxy(frontend.Name) {
+			continue
+		}
+
+		bindsResp, err := s.HAProxyClient.ListBinds(ctx, &haproxyv1.ListBindsRequest{
+			FrontendName: frontend.Name,
+		})
+		if err != nil {
+			klog.Errorf("list bind error: %v", err.Error())
+			return nil, err
+		}
+		for _, bind := range bindsResp.Binds {
+			for name, binds := range added {
+				for _, want := range binds {
+					if want.Port != bind.Port || !overlaps(want.Address, bind.Address) {
+						continue
+					}
+					conflicts.reject(owner.namer, name, PortErrorPortConflict,
+						fmt.Sprintf("%s port %d is already bound by frontend %s", want.Address, want.Port, frontend.Name))
+				}
+			}
+		}
+	}
+
+	return conflicts, nil
+}
+
+// overlaps reports whether binds on both addresses listen on a common
+// address. An empty or unspecified address listens on every address.
+func overlaps(a, b string) bool {
+	if a == "" || b == "" {
+		return true
+	}
+
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+
+	return addrA.IsUnspecified() || addrB.IsUnspecified() || addrA.Unmap() == addrB.Unmap()
+}
@@ -1,25 +1,110 @@
 package controllers
 
 import (
+	"context"
+	"github.com/bear-san/haproxy-ccm/ipam"
+	"github.com/bear-san/haproxy-ccm/model"
+	"github.com/bear-san/haproxy-ccm/transaction"
 	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
 	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+	"time"
 )
 
 type Provider struct {
 	cloudprovider.Interface
 	HAProxyClient haproxyv1.HAProxyManagerServiceClient
 	Connection    *grpc.ClientConn
+	IPAM          *ipam.Allocator
+	Options       model.Options
+	// ClusterID identifies the cluster in the names of HAProxy objects.
+	// Without it the --cluster-name given to the CCM is used.
+	ClusterID   string
+	ClusterName string
+	// IPMode is reported for every load balancer address.
+	IPMode v1.LoadBalancerIPMode
+	// GCInterval is the period of the orphan sweep, zero disables it.
+	GCInterval   time.Duration
+	GCReportOnly bool
+	// Recorder is set by Initialize.
+	Recorder record.EventRecorder
+	// EndpointSlices is set by SetInformers.
+	EndpointSlices discoverylisters.EndpointSliceLister
+
+	// ctx is done when the controller manager stops.
+	ctx context.Context
+}
+
+func (p *Provider) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
+	ctx := wait.ContextForChannel(stop)
+	p.ctx = ctx
+	client := clientBuilder.ClientOrDie("haproxy-ccm")
+
+	broadcaster := record.NewBroadcaster(record.WithContext(ctx))
+	broadcaster.StartStructuredLogging(0)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	p.Recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "haproxy-ccm"})
+
+	if p.IPAM != nil {
+		// allocations must be restored before any Service is reconciled
+		if err := wait.PollUntilContextCancel(ctx, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			if err := restoreAllocations(ctx, client, p.IPAM); err != nil {
+				klog.Errorf("restore IP allocations error: %v", err.Error())
+				return false, nil
+			}
+			return true, nil
+		}); err != nil {
+			klog.Errorf("restore IP allocations error: %v", err.Error())
+		}
+	}
+
+	if p.GCInterval > 0 {
+		gc := &GarbageCollector{
+			Client:       client,
+			LoadBalancer: p.serviceController(),
+			ClusterName:  p.ClusterName,
+			Interval:     p.GCInterval,
+			ReportOnly:   p.GCReportOnly,
+		}
+		go gc.Run(ctx)
+	}
 }
 
-func (p *Provider) Initialize(_ cloudprovider.ControllerClientBuilder, _ <-chan struct{}) {
+// SetInformers is called after Initialize, before the informers are started.
+func (p *Provider) SetInformers(informerFactory informers.SharedInformerFactory) {
+	p.EndpointSlices = informerFactory.Discovery().V1().EndpointSlices().Lister()
 
+	watcher, err := NewEndpointsWatcher(p.serviceController(), p.ClusterName, informerFactory)
+	if err != nil {
+		klog.Errorf("watch endpoint slices error: %v", err.Error())
+		return
+	}
+	go watcher.Run(p.ctx)
 }
 
 func (p *Provider) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	return p.serviceController(), true
+}
+
+func (p *Provider) serviceController() *ServiceController {
 	return &ServiceController{
-		HAProxyClient: p.HAProxyClient,
-	}, true
+		HAProxyClient:  p.HAProxyClient,
+		Transactions:   transaction.NewRunner(p.HAProxyClient),
+		IPAM:           p.IPAM,
+		Options:        p.Options,
+		Recorder:       p.Recorder,
+		IPMode:         p.IPMode,
+		EndpointSlices: p.EndpointSlices,
+		ClusterID:      p.ClusterID,
+	}
 }
 
 func (p *Provider) Instances() (cloudprovider.Instances, bool) {
@@ -38,6 +123,9 @@ func (p *Provider) Routes() (cloudprovider.Routes, bool) {
 	return nil, false
 }
 
+// HasClusterID only counts an explicit ClusterID. The --cluster-name
+// fallback defaults to "kubernetes", which two clusters installed with
+// defaults would share.
 func (p *Provider) HasClusterID() bool {
-	return true
+	return p.ClusterID != ""
 }
@@ -2,494 +2,315 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"github.com/bear-san/haproxy-ccm/ipam"
+	"github.com/bear-san/haproxy-ccm/model"
+	"github.com/bear-san/haproxy-ccm/transaction"
 	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
+	"maps"
+	"net/netip"
+	"slices"
 	"strings"
 )
 
 type ServiceController struct {
 	cloudprovider.LoadBalancer
 	HAProxyClient haproxyv1.HAProxyManagerServiceClient
+	Transactions  *transaction.Runner
+	IPAM          *ipam.Allocator
+	Options       model.Options
+	// Recorder records events on Services, it may be nil.
+	Recorder record.EventRecorder
+	// EndpointSlices are read in the pod backend mode.
+	EndpointSlices discoverylisters.EndpointSliceLister
+	// ClusterID names the cluster in place of the clusterName passed by the
+	// service controller when set.
+	ClusterID string
+	// IPMode is reported for every address, none when empty.
+	IPMode v1.LoadBalancerIPMode
 }
 
-func (s *ServiceController) UpdateLoadBalancer(_ context.Context, _ string, service *v1.Service, nodes []*v1.Node) error {
+func (s *ServiceController) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
 	klog.Info("Updating HAProxy LoadBalancer...")
-	if _, err := s.reconcileLoadBalancer(context.Background(), service, nodes); err != nil {
+	// only the servers of added or removed nodes differ from HAProxy
+	if _, err := s.reconcileLoadBalancer(ctx, s.options(clusterName), service, nodes); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *ServiceController) GetLoadBalancerName(_ context.Context, _ string, service *v1.Service) string {
-	return fmt.Sprintf("haproxy-%s", service.UID)
+func (s *ServiceController) GetLoadBalancerName(_ context.Context, clusterName string, service *v1.Service) string {
+	return model.ResourcePrefix(service, s.options(clusterName))
 }
 
-func (s *ServiceController) GetLoadBalancer(_ context.Context, _ string, service *v1.Service) (status *v1.LoadBalancerStatus, exists bool, err error) {
-	return &service.Status.LoadBalancer, true, nil
-}
-
-func (s *ServiceController) EnsureLoadBalancerDeleted(ctx context.Context, _ string, service *v1.Service) error {
-	klog.Info("Deleting HAProxy LoadBalancer...")
-	versionResp, err := s.HAProxyClient.GetVersion(ctx, &haproxyv1.GetVersionRequest{})
-	if err != nil {
-		klog.Errorf("get current version error: %v", err.Error())
-		return err
-	}
-	transactionResp, err := s.HAProxyClient.CreateTransaction(ctx, &haproxyv1.CreateTransactionRequest{
-		Version: versionResp.Version,
-	})
+func (s *ServiceController) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (status *v1.LoadBalancerStatus, exists bool, err error) {
+	observed, err := s.observeLoadBalancer(ctx, serviceOwner(service, s.options(clusterName)))
 	if err != nil {
-		klog.Errorf("create transaction error: %v", err.Error())
-		return err
+		return nil, false, err
 	}
 
-	resourcePrefix := fmt.Sprintf("haproxy-%s-", service.UID)
-
-	// delete all frontends and binds
-	frontendsResp, err := s.HAProxyClient.ListFrontends(ctx, &haproxyv1.ListFrontendsRequest{
-		TransactionId: transactionResp.Transaction.Id,
-	})
-	if err != nil {
-		klog.Errorf("list frontend error: %v", err.Error())
-		if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-			TransactionId: transactionResp.Transaction.Id,
-		}); closeTransactionErr != nil {
-			klog.Errorf("close transaction error: %v", err.Error())
-		}
-		return err
+	// the service controller only deletes load balancers that exist, so a
+	// Service without any frontend, e.g. one with only UDP ports, still
+	// exists while it holds a backend or an address
+	var allocated []netip.Addr
+	if s.IPAM != nil {
+		allocated = s.IPAM.Lookup(allocationOwner(service))
+	}
+	if len(observed.Frontends) == 0 && len(observed.Backends) == 0 && len(allocated) == 0 {
+		return nil, false, nil
 	}
 
-	for _, frontend := range frontendsResp.Frontends {
-		if !strings.HasPrefix(frontend.Name, resourcePrefix) {
-			continue
-		}
-
-		bindsResp, err := s.HAProxyClient.ListBinds(ctx, &haproxyv1.ListBindsRequest{
-			FrontendName:  frontend.Name,
-			TransactionId: transactionResp.Transaction.Id,
-		})
-		if err != nil {
-			klog.Errorf("list bind error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
-			}
-			return err
+	bound, addresses := boundPorts(observed)
+	if len(addresses) == 0 {
+		for _, addr := range allocated {
+			addresses = append(addresses, addr.String())
 		}
+	}
 
-		for _, bind := range bindsResp.Binds {
-			_, err := s.HAProxyClient.DeleteBind(ctx, &haproxyv1.DeleteBindRequest{
-				Name:          bind.Name,
-				FrontendName:  frontend.Name,
-				TransactionId: transactionResp.Transaction.Id,
-			})
-			if err != nil {
-				klog.Errorf("delete bind error: %v", err.Error())
-				if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-					TransactionId: transactionResp.Transaction.Id,
-				}); closeTransactionErr != nil {
-					klog.Errorf("close transaction error: %v", err.Error())
-				}
-				return err
-			}
-		}
+	return s.loadBalancerStatus(service, addresses, unsupportedPorts(service), bound.served), true, nil
+}
 
-		_, err = s.HAProxyClient.DeleteFrontend(ctx, &haproxyv1.DeleteFrontendRequest{
-			Name:          frontend.Name,
-			TransactionId: transactionResp.Transaction.Id,
-		})
-		if err != nil {
-			klog.Errorf("delete frontend error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
+// boundPorts returns the ports bound by the frontends of lb on each address,
+// and the addresses in the order of the frontends.
+func boundPorts(lb *model.LoadBalancer) (bindings, []string) {
+	bound := bindings{}
+	var addresses []string
+	for _, frontendName := range slices.Sorted(maps.Keys(lb.Frontends)) {
+		frontend := lb.Frontends[frontendName]
+		for _, bindName := range slices.Sorted(maps.Keys(frontend.Binds)) {
+			bind := frontend.Binds[bindName]
+			if _, ok := bound[bind.Address]; !ok {
+				bound[bind.Address] = sets.New[int32]()
+				addresses = append(addresses, bind.Address)
 			}
-			return err
+			bound[bind.Address].Insert(bind.Port)
 		}
 	}
 
-	// delete all backends and servers
-	backendsResp, err := s.HAProxyClient.ListBackends(ctx, &haproxyv1.ListBackendsRequest{
-		TransactionId: transactionResp.Transaction.Id,
-	})
-	if err != nil {
-		klog.Errorf("list backend error: %v", err.Error())
-		if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-			TransactionId: transactionResp.Transaction.Id,
-		}); closeTransactionErr != nil {
-			klog.Errorf("close transaction error: %v", err.Error())
-		}
-		return err
-	}
+	return bound, addresses
+}
 
-	for _, backend := range backendsResp.Backends {
-		if !strings.HasPrefix(backend.Name, resourcePrefix) {
-			continue
-		}
+// bindings holds the ports bound on each address.
+type bindings map[string]sets.Set[int32]
 
-		serversResp, err := s.HAProxyClient.ListServers(ctx, &haproxyv1.ListServersRequest{
-			BackendName:   backend.Name,
-			TransactionId: transactionResp.Transaction.Id,
-		})
-		if err != nil {
-			klog.Errorf("list server error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
-			}
-			return err
-		}
+func (b bindings) served(address string, port v1.ServicePort) bool {
+	return b[address].Has(port.Port)
+}
 
-		for _, server := range serversResp.Servers {
-			_, err := s.HAProxyClient.DeleteServer(ctx, &haproxyv1.DeleteServerRequest{
-				Name:          server.Name,
-				BackendName:   backend.Name,
-				TransactionId: transactionResp.Transaction.Id,
-			})
-			if err != nil {
-				klog.Errorf("delete server error: %v", err.Error())
-				if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-					TransactionId: transactionResp.Transaction.Id,
-				}); closeTransactionErr != nil {
-					klog.Errorf("close transaction error: %v", err.Error())
-				}
-				return err
-			}
-		}
+func (s *ServiceController) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	klog.Info("Deleting HAProxy LoadBalancer...")
+	owner := serviceOwner(service, s.options(clusterName))
 
-		_, err = s.HAProxyClient.DeleteBackend(ctx, &haproxyv1.DeleteBackendRequest{
-			Name:          backend.Name,
-			TransactionId: transactionResp.Transaction.Id,
-		})
+	// an empty desired state deletes every frontend, bind, backend and server
+	if err := s.Transactions.Run(ctx, func(ctx context.Context) ([]transaction.Step, error) {
+		observed, err := s.observeLoadBalancer(ctx, owner)
 		if err != nil {
-			klog.Errorf("delete backend error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
-			}
-			return err
+			return nil, err
 		}
-	}
-
-	if _, err := s.HAProxyClient.CommitTransaction(ctx, &haproxyv1.CommitTransactionRequest{
-		TransactionId: transactionResp.Transaction.Id,
+		return s.diffLoadBalancer(model.NewLoadBalancer(), observed), nil
 	}); err != nil {
-		klog.Errorf("commit transaction error: %v", err.Error())
-		if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-			TransactionId: transactionResp.Transaction.Id,
-		}); closeTransactionErr != nil {
-			klog.Errorf("close transaction error: %v", err.Error())
-		}
-
+		klog.Errorf("delete load balancer error: %v", err.Error())
 		return err
 	}
 
+	if s.IPAM != nil {
+		s.IPAM.Release(allocationOwner(service))
+	}
+
 	return nil
 }
 
-func (s *ServiceController) EnsureLoadBalancer(ctx context.Context, _ string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+func (s *ServiceController) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
 	klog.Info("Creating HAProxy LoadBalancer...")
-	return s.reconcileLoadBalancer(ctx, service, nodes)
+	return s.reconcileLoadBalancer(ctx, s.options(clusterName), service, nodes)
 }
 
-func (s *ServiceController) reconcileLoadBalancer(ctx context.Context, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
-	if len(service.Spec.ExternalIPs) == 0 {
-		// Not implemented
-		return nil, fmt.Errorf("auto assign IP not implemented")
-	}
-
-	newStatus := v1.LoadBalancerStatus{
-		Ingress: []v1.LoadBalancerIngress{},
-	}
-
-	if len(service.Spec.ExternalIPs) == 0 {
-		// Not implemented
-		return nil, fmt.Errorf("auto assign IP not implemented")
-	}
-
-	versionResp, err := s.HAProxyClient.GetVersion(ctx, &haproxyv1.GetVersionRequest{})
-	if err != nil {
-		klog.Errorf("get current version error: %v", err.Error())
-		return nil, err
-	}
-	transactionResp, err := s.HAProxyClient.CreateTransaction(ctx, &haproxyv1.CreateTransactionRequest{
-		Version: versionResp.Version,
-	})
-	if err != nil {
-		klog.Errorf("create transaction error: %v", err.Error())
-		return nil, err
-	}
-
-	resourcePrefix := fmt.Sprintf("haproxy-%s", service.UID)
-
-	backendsResp, err := s.HAProxyClient.ListBackends(ctx, &haproxyv1.ListBackendsRequest{
-		TransactionId: transactionResp.Transaction.Id,
-	})
+// reconcileLoadBalancer applies every port of service independently. Ports
+// that cannot be load balanced are reported in the status, and ports whose
+// calls fail are left as they are while the others are applied, then
+// returned as an error so that the service controller retries them.
+func (s *ServiceController) reconcileLoadBalancer(ctx context.Context, opts model.Options, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	addresses, err := s.loadBalancerAddresses(service)
 	if err != nil {
-		klog.Errorf("list backend error: %v", err.Error())
-		if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-			TransactionId: transactionResp.Transaction.Id,
-		}); closeTransactionErr != nil {
-			klog.Errorf("close transaction error: %v", err.Error())
-		}
+		klog.Errorf("assign load balancer address error: %v", err.Error())
 		return nil, err
 	}
 
-	// delete all backends and servers
-	for _, backend := range backendsResp.Backends {
-		if !strings.HasPrefix(backend.Name, resourcePrefix) {
-			continue
-		}
-
-		serversResp, err := s.HAProxyClient.ListServers(ctx, &haproxyv1.ListServersRequest{
-			BackendName:   backend.Name,
-			TransactionId: transactionResp.Transaction.Id,
-		})
+	errs := unsupportedPorts(service)
+	opts, annotationErr := serviceOptions(service, opts)
+	if annotationErr != nil {
+		// HAProxy keeps the previous configuration until the annotations are
+		// fixed, so only the ports it does not serve yet are reported
+		observed, err := s.observeLoadBalancer(ctx, serviceOwner(service, opts))
 		if err != nil {
-			klog.Errorf("list server error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
-			}
 			return nil, err
 		}
-
-		for _, server := range serversResp.Servers {
-			_, err := s.HAProxyClient.DeleteServer(ctx, &haproxyv1.DeleteServerRequest{
-				Name:          server.Name,
-				BackendName:   backend.Name,
-				TransactionId: transactionResp.Transaction.Id,
-			})
-			if err != nil {
-				klog.Errorf("delete server error: %v", err.Error())
-				if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-					TransactionId: transactionResp.Transaction.Id,
-				}); closeTransactionErr != nil {
-					klog.Errorf("close transaction error: %v", err.Error())
-				}
-				return nil, err
-			}
-		}
-
-		_, err = s.HAProxyClient.DeleteBackend(ctx, &haproxyv1.DeleteBackendRequest{
-			Name:          backend.Name,
-			TransactionId: transactionResp.Transaction.Id,
-		})
+		bound, _ := boundPorts(observed)
+		errs.rejectUnserved(service, addresses, bound.served, PortErrorInvalidAnnotation, annotationErr.Error())
+		s.event(service, v1.EventTypeWarning, strings.TrimPrefix(PortErrorInvalidAnnotation, "haproxy-ccm/"),
+			"HAProxy keeps the previous configuration of the Service: %v", annotationErr)
+		s.warnPortErrors(service, errs)
+		return s.loadBalancerStatus(service, addresses, errs, bound.served), nil
+	}
+	var endpointSlices []*discoveryv1.EndpointSlice
+	if opts.BackendMode == model.BackendPod {
+		endpointSlices, err = s.endpointSlices(service)
 		if err != nil {
-			klog.Errorf("delete backend error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
-			}
+			klog.Errorf("list endpoint slices error: %v", err.Error())
 			return nil, err
 		}
 	}
 
-	frontendsResp, err := s.HAProxyClient.ListFrontends(ctx, &haproxyv1.ListFrontendsRequest{
-		TransactionId: transactionResp.Transaction.Id,
-	})
-	if err != nil {
-		klog.Errorf("list frontend error: %v", err.Error())
-		if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-			TransactionId: transactionResp.Transaction.Id,
-		}); closeTransactionErr != nil {
-			klog.Errorf("close transaction error: %v", err.Error())
+	owner := serviceOwner(service, opts)
+	desired := model.Build(service, nodes, endpointSlices, addresses, opts)
+	for _, name := range slices.Sorted(maps.Keys(desired.Backends)) {
+		if err := validateBalance(desired.Backends[name]); err != nil {
+			klog.Errorf("balance algorithm error: %v", err.Error())
+			errs.reject(owner.namer, name, PortErrorInvalidAnnotation, err.Error())
 		}
-		return nil, err
 	}
-	for _, frontend := range frontendsResp.Frontends {
-		if !strings.HasPrefix(frontend.Name, resourcePrefix) {
-			continue
-		}
 
-		bindsResp, err := s.HAProxyClient.ListBinds(ctx, &haproxyv1.ListBindsRequest{
-			FrontendName:  frontend.Name,
-			TransactionId: transactionResp.Transaction.Id,
-		})
-		if err != nil {
-			klog.Errorf("list bind error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
+	// only the difference between HAProxy and the Service is applied, the
+	// ports that failed are left out of the next attempt
+	failed := map[string]error{}
+	var conflicts portErrors
+	for {
+		err := s.Transactions.Run(ctx, func(ctx context.Context) ([]transaction.Step, error) {
+			observed, err := s.observeLoadBalancer(ctx, owner)
+			if err != nil {
+				return nil, err
 			}
-			return nil, err
-		}
 
-		for _, bind := range bindsResp.Binds {
-			_, err := s.HAProxyClient.DeleteBind(ctx, &haproxyv1.DeleteBindRequest{
-				Name:          bind.Name,
-				FrontendName:  frontend.Name,
-				TransactionId: transactionResp.Transaction.Id,
-			})
+			kept := errs.names(owner.namer, service.UID)
+			for group := range failed {
+				kept.Insert(group)
+			}
+			conflicts, err = s.portConflicts(ctx, owner, keepObserved(desired, observed, kept), observed)
 			if err != nil {
-				klog.Errorf("delete bind error: %v", err.Error())
-
-				if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-					TransactionId: transactionResp.Transaction.Id,
-				}); closeTransactionErr != nil {
-					klog.Errorf("close transaction error: %v", err.Error())
-				}
 				return nil, err
 			}
-		}
-		_, err = s.HAProxyClient.DeleteFrontend(ctx, &haproxyv1.DeleteFrontendRequest{
-			Name:          frontend.Name,
-			TransactionId: transactionResp.Transaction.Id,
+			kept = kept.Union(conflicts.names(owner.namer, service.UID))
+
+			return s.diffLoadBalancer(keepObserved(desired, observed, kept), observed), nil
 		})
-		if err != nil {
-			klog.Errorf("delete frontend error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
-			}
-			return nil, err
+		if err == nil {
+			break
 		}
-	}
 
-	// create a new backend and backend servers
-	for _, port := range service.Spec.Ports {
-		resourceName := fmt.Sprintf("%s-%s-%s", resourcePrefix, port.Name, port.Protocol)
-		_, err := s.HAProxyClient.CreateBackend(ctx, &haproxyv1.CreateBackendRequest{
-			Backend: &haproxyv1.Backend{
-				Name: resourceName,
-				Mode: haproxyv1.ProxyMode_PROXY_MODE_TCP,
-				Balance: &haproxyv1.BackendBalance{
-					Algorithm: haproxyv1.BalanceAlgorithm_BALANCE_ALGORITHM_ROUNDROBIN,
-				},
-			},
-			TransactionId: transactionResp.Transaction.Id,
-		})
-		if err != nil {
-			klog.Errorf("create backend error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
-			}
+		var stepErr *transaction.StepError
+		if !errors.As(err, &stepErr) || stepErr.Group == "" || failed[stepErr.Group] != nil ||
+			transaction.IsConflict(stepErr.Err) || ctx.Err() != nil {
+			klog.Errorf("reconcile load balancer error: %v", err.Error())
 			return nil, err
 		}
+		klog.Errorf("reconcile load balancer %s error: %v", stepErr.Group, err.Error())
+		failed[stepErr.Group] = err
+	}
 
-		for i, node := range nodes {
-			nodeIp := ""
-			for _, address := range node.Status.Addresses {
-				if address.Type == v1.NodeInternalIP {
-					nodeIp = address.Address
-					break
-				}
-			}
-
-			// skip if node doesn't have internal IP
-			if nodeIp == "" {
-				continue
-			}
-			nodePort := int32(port.NodePort)
-			serverName := fmt.Sprintf("server-%s-%s-%d-%d", service.UID, node.Name, port.NodePort, i)
-			_, err = s.HAProxyClient.CreateServer(ctx, &haproxyv1.CreateServerRequest{
-				Server: &haproxyv1.Server{
-					Name:    serverName,
-					Address: nodeIp,
-					Port:    nodePort,
-				},
-				BackendName:   resourceName,
-				TransactionId: transactionResp.Transaction.Id,
-			})
-			if err != nil {
-				klog.Errorf("create server error: %v", err.Error())
-				if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-					TransactionId: transactionResp.Transaction.Id,
-				}); closeTransactionErr != nil {
-					klog.Errorf("close transaction error: %v", err.Error())
-				}
-				return nil, err
-			}
+	maps.Copy(errs, conflicts)
+	s.warnPortErrors(service, errs)
+	if len(failed) > 0 {
+		var joined []error
+		for _, group := range slices.Sorted(maps.Keys(failed)) {
+			joined = append(joined, failed[group])
 		}
+		return nil, errors.Join(joined...)
 	}
 
-	// Create new frontend if not exists
-	for _, port := range service.Spec.Ports {
-		resourceName := fmt.Sprintf("%s-%s-%s", resourcePrefix, port.Name, port.Protocol)
-		_, err := s.HAProxyClient.CreateFrontend(ctx, &haproxyv1.CreateFrontendRequest{
-			Frontend: &haproxyv1.Frontend{
-				Name:           resourceName,
-				Mode:           haproxyv1.ProxyMode_PROXY_MODE_TCP,
-				DefaultBackend: resourceName,
-			},
-			TransactionId: transactionResp.Transaction.Id,
-		})
-		if err != nil {
-			klog.Errorf("create frontend error: %v", err.Error())
-			if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-				TransactionId: transactionResp.Transaction.Id,
-			}); closeTransactionErr != nil {
-				klog.Errorf("close transaction error: %v", err.Error())
-			}
-			return nil, err
-		}
+	return s.loadBalancerStatus(service, addresses, errs, func(string, v1.ServicePort) bool {
+		return true
+	}), nil
+}
 
-		for _, ip := range service.Spec.ExternalIPs {
-			bindName := fmt.Sprintf("%s-%s-%s", resourcePrefix, ip, port.Protocol)
-			portNum := int32(port.Port)
-			_, err := s.HAProxyClient.CreateBind(ctx, &haproxyv1.CreateBindRequest{
-				Bind: &haproxyv1.Bind{
-					Name:    bindName,
-					Address: ip,
-					Port:    portNum,
-				},
-				FrontendName:  resourceName,
-				TransactionId: transactionResp.Transaction.Id,
-			})
-			if err != nil {
-				klog.Errorf("create bind error: %v", err.Error())
-				if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-					TransactionId: transactionResp.Transaction.Id,
-				}); closeTransactionErr != nil {
-					klog.Errorf("close transaction error: %v", err.Error())
-				}
-				return nil, err
-			}
-		}
+// serviceOptions applies the annotations of service to opts.
+func serviceOptions(service *v1.Service, opts model.Options) (model.Options, error) {
+	var err error
+
+	opts.BackendMode, err = backendMode(service, opts.BackendMode)
+	if err != nil {
+		klog.Errorf("backend mode error: %v", err.Error())
+		return opts, err
+	}
+	opts.BalanceAlgorithm, err = balanceAlgorithm(service, opts.BalanceAlgorithm)
+	if err != nil {
+		klog.Errorf("balance algorithm error: %v", err.Error())
+		return opts, err
+	}
+	opts.HTTPPorts, err = httpPorts(service)
+	if err != nil {
+		klog.Errorf("http ports error: %v", err.Error())
+		return opts, err
+	}
+	opts.SourceRanges, err = sourceRanges(service)
+	if err != nil {
+		klog.Errorf("source ranges error: %v", err.Error())
+		return opts, err
+	}
+	opts.HealthCheck, err = serviceHealthCheck(service, opts.HealthCheck)
+	if err != nil {
+		klog.Errorf("health check error: %v", err.Error())
+		return opts, err
 	}
 
-	if _, err := s.HAProxyClient.CommitTransaction(ctx, &haproxyv1.CommitTransactionRequest{
-		TransactionId: transactionResp.Transaction.Id,
-	}); err != nil {
-		klog.Errorf("commit transaction error: %v", err.Error())
-		if _, closeTransactionErr := s.HAProxyClient.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
-			TransactionId: transactionResp.Transaction.Id,
-		}); closeTransactionErr != nil {
-			klog.Errorf("close transaction error: %v", err.Error())
-		}
-		return nil, err
+	return opts, nil
+}
+
+// loadBalancerStatus lists the Service ports served on each address in one
+// ingress per address. Ports that are not load balanced are listed with
+// their error.
+func (s *ServiceController) loadBalancerStatus(service *v1.Service, addresses []string, errs portErrors, served func(address string, port v1.ServicePort) bool) *v1.LoadBalancerStatus {
+	status := &v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{},
 	}
 
-	for _, externalIP := range service.Spec.ExternalIPs {
+	for _, externalIP := range addresses {
 		if externalIP == "" {
 			continue
 		}
+
+		ingress := v1.LoadBalancerIngress{
+			IP: externalIP,
+		}
+		if s.IPMode != "" {
+			ipMode := s.IPMode
+			ingress.IPMode = &ipMode
+		}
 		for _, port := range service.Spec.Ports {
-			newStatus.Ingress = append(newStatus.Ingress, v1.LoadBalancerIngress{
-				IP: externalIP,
-				Ports: []v1.PortStatus{
-					{
-						Port:     port.Port,
-						Protocol: port.Protocol,
-					},
-				},
-			})
+			portStatus := v1.PortStatus{
+				Port:     port.Port,
+				Protocol: port.Protocol,
+			}
+			if portErr, ok := errs[keyOf(port)]; ok {
+				portStatus.Error = &portErr.Reason
+			} else if !served(externalIP, port) {
+				continue
+			}
+			ingress.Ports = append(ingress.Ports, portStatus)
+		}
+		if len(ingress.Ports) > 0 {
+			status.Ingress = append(status.Ingress, ingress)
 		}
 	}
 
-	return &newStatus, nil
+	return status
+}
+
+// options returns the naming options for the cluster the service controller
+// passes in, unless a ClusterID is configured.
+func (s *ServiceController) options(clusterName string) model.Options {
+	opts := s.Options
+	opts.ClusterName = clusterName
+	if s.ClusterID != "" {
+		opts.ClusterName = s.ClusterID
+	}
+
+	return opts
 }
@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	v1 "k8s.io/api/core/v1"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	"slices"
+)
+
+// sourceRanges returns the sorted CIDRs allowed to connect to service, from
+// spec.loadBalancerSourceRanges or the
+// service.beta.kubernetes.io/load-balancer-source-ranges annotation. It
+// returns nil when every client is allowed.
+func sourceRanges(service *v1.Service) ([]string, error) {
+	ranges, err := servicehelpers.GetLoadBalancerSourceRanges(service)
+	if err != nil {
+		return nil, err
+	}
+	if servicehelpers.IsAllowAll(ranges) {
+		return nil, nil
+	}
+
+	cidrs := ranges.StringSlice()
+	slices.Sort(cidrs)
+
+	return cidrs, nil
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"slices"
+	"testing"
+)
+
+func TestSourceRanges(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		spec       []string
+		annotation string
+		want       []string
+		wantErr    bool
+	}{
+		{name: "unset"},
+		{name: "spec", spec: []string{"192.0.2.0/24", "10.0.0.0/8"}, want: []string{"10.0.0.0/8", "192.0.2.0/24"}},
+		{name: "annotation", annotation: "10.0.0.0/8, 2001:db8::/32", want: []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{name: "spec before annotation", spec: []string{"192.0.2.0/24"}, annotation: "10.0.0.0/8", want: []string{"192.0.2.0/24"}},
+		{name: "allow all", spec: []string{"10.0.0.0/8", "0.0.0.0/0"}},
+		{name: "invalid", annotation: "10.0.0.0/33", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{Spec: v1.ServiceSpec{LoadBalancerSourceRanges: tt.spec}}
+			if tt.annotation != "" {
+				service.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{v1.AnnotationLoadBalancerSourceRangesKey: tt.annotation}}
+			}
+
+			got, err := sourceRanges(service)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sourceRanges() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("sourceRanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
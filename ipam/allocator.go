@@ -0,0 +1,137 @@
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+)
+
+var ErrPoolExhausted = errors.New("no free address left in the configured pools")
+
+// Allocator hands out addresses from a set of pools. Every allocation is
+// tied to an owner (the Service namespace/name), so allocating twice for the
+// same owner returns the same address.
+type Allocator struct {
+	mu        sync.Mutex
+	pools     []*Pool
+	allocated map[netip.Addr]string
+	owners    map[string][]netip.Addr
+}
+
+func NewAllocator(pools []*Pool) *Allocator {
+	return &Allocator{
+		pools:     pools,
+		allocated: map[netip.Addr]string{},
+		owners:    map[string][]netip.Addr{},
+	}
+}
+
+// Contains reports whether addr belongs to one of the configured pools.
+func (a *Allocator) Contains(addr netip.Addr) bool {
+	for _, pool := range a.pools {
+		if pool.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lookup returns the addresses currently held by owner.
+func (a *Allocator) Lookup(owner string) []netip.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]netip.Addr(nil), a.owners[owner]...)
+}
+
+// Allocate returns the address held by owner, or assigns the first free
+// address from the pools.
+func (a *Allocator) Allocate(owner string) (netip.Addr, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if addrs := a.owners[owner]; len(addrs) > 0 {
+		return addrs[0], nil
+	}
+
+	for _, pool := range a.pools {
+		for addr := pool.first; addr.IsValid() && !pool.last.Less(addr); addr = addr.Next() {
+			if _, ok := a.allocated[addr]; ok {
+				continue
+			}
+
+			a.allocated[addr] = owner
+			a.owners[owner] = append(a.owners[owner], addr)
+			return addr, nil
+		}
+	}
+
+	return netip.Addr{}, ErrPoolExhausted
+}
+
+// Reserve marks addr as held by owner. It fails if the address is outside
+// the pools or already held by another owner.
+func (a *Allocator) Reserve(owner string, addr netip.Addr) error {
+	addr = addr.Unmap()
+	if !a.Contains(addr) {
+		return fmt.Errorf("address %s is not in any configured pool", addr)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if current, ok := a.allocated[addr]; ok {
+		if current != owner {
+			return fmt.Errorf("address %s is already allocated to %s", addr, current)
+		}
+		return nil
+	}
+
+	a.allocated[addr] = owner
+	a.owners[owner] = append(a.owners[owner], addr)
+
+	return nil
+}
+
+// Assign replaces the addresses held by owner with addrs. Nothing changes
+// if any of them is outside the pools or held by another owner.
+func (a *Allocator) Assign(owner string, addrs []netip.Addr) error {
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+		if !a.Contains(addrs[i]) {
+			return fmt.Errorf("address %s is not in any configured pool", addr)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, addr := range addrs {
+		if current, ok := a.allocated[addr]; ok && current != owner {
+			return fmt.Errorf("address %s is already allocated to %s", addr, current)
+		}
+	}
+
+	for _, addr := range a.owners[owner] {
+		delete(a.allocated, addr)
+	}
+	for _, addr := range addrs {
+		a.allocated[addr] = owner
+	}
+	a.owners[owner] = append([]netip.Addr(nil), addrs...)
+
+	return nil
+}
+
+// Release frees every address held by owner.
+func (a *Allocator) Release(owner string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, addr := range a.owners[owner] {
+		delete(a.allocated, addr)
+	}
+	delete(a.owners, owner)
+}
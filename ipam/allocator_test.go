@@ -0,0 +1,191 @@
+package ipam
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func testAllocator(t *testing.T, pools ...string) *Allocator {
+	t.Helper()
+
+	var parsed []*Pool
+	for _, item := range pools {
+		pool, err := ParsePool(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, pool)
+	}
+
+	return NewAllocator(parsed)
+}
+
+func addrs(values ...string) []netip.Addr {
+	var parsed []netip.Addr
+	for _, value := range values {
+		parsed = append(parsed, netip.MustParseAddr(value))
+	}
+
+	return parsed
+}
+
+func TestAllocate(t *testing.T) {
+	a := testAllocator(t, "192.0.2.0/30", "2001:db8::1-2001:db8::1")
+
+	for _, tt := range []struct {
+		owner   string
+		want    string
+		wantErr error
+	}{
+		{owner: "default/a", want: "192.0.2.1"},
+		// the same owner keeps its address
+		{owner: "default/a", want: "192.0.2.1"},
+		{owner: "default/b", want: "192.0.2.2"},
+		// the next pool is used once the first is full
+		{owner: "default/c", want: "2001:db8::1"},
+		{owner: "default/d", wantErr: ErrPoolExhausted},
+	} {
+		got, err := a.Allocate(tt.owner)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("Allocate(%q) error = %v, want %v", tt.owner, err, tt.wantErr)
+		}
+		if tt.wantErr == nil && got.String() != tt.want {
+			t.Errorf("Allocate(%q) = %s, want %s", tt.owner, got, tt.want)
+		}
+	}
+
+	a.Release("default/b")
+	if got, err := a.Allocate("default/d"); err != nil || got.String() != "192.0.2.2" {
+		t.Errorf("Allocate after Release = %s, %v, want 192.0.2.2", got, err)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	a := testAllocator(t, "192.0.2.0/29")
+	if err := a.Reserve("default/a", netip.MustParseAddr("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		owner   string
+		addr    string
+		wantErr bool
+	}{
+		{name: "held by the owner", owner: "default/a", addr: "192.0.2.1"},
+		{name: "held by another owner", owner: "default/b", addr: "192.0.2.1", wantErr: true},
+		{name: "mapped address held by another owner", owner: "default/b", addr: "::ffff:192.0.2.1", wantErr: true},
+		{name: "free", owner: "default/b", addr: "192.0.2.2"},
+		{name: "network address", owner: "default/c", addr: "192.0.2.0", wantErr: true},
+		{name: "outside the pools", owner: "default/c", addr: "198.51.100.1", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.Reserve(tt.owner, netip.MustParseAddr(tt.addr))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Reserve(%q, %s) error = %v, want error %v", tt.owner, tt.addr, err, tt.wantErr)
+			}
+		})
+	}
+
+	// reserved addresses are never allocated to anyone else
+	for _, owner := range []string{"default/c", "default/d", "default/e", "default/f"} {
+		got, err := a.Allocate(owner)
+		if err != nil {
+			t.Fatalf("Allocate(%q) error = %v", owner, err)
+		}
+		if got.String() == "192.0.2.1" || got.String() == "192.0.2.2" {
+			t.Errorf("Allocate(%q) = %s, which is already reserved", owner, got)
+		}
+	}
+	if _, err := a.Allocate("default/g"); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Allocate() error = %v, want %v", err, ErrPoolExhausted)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		addrs   []netip.Addr
+		want    []netip.Addr
+		wantErr bool
+	}{
+		{
+			name:  "replaces the held addresses",
+			addrs: addrs("192.0.2.3", "2001:db8::2"),
+			want:  addrs("192.0.2.3", "2001:db8::2"),
+		},
+		{
+			name:  "keeps a held address",
+			addrs: addrs("192.0.2.1", "192.0.2.3"),
+			want:  addrs("192.0.2.1", "192.0.2.3"),
+		},
+		{
+			name:  "releases everything",
+			addrs: nil,
+			want:  nil,
+		},
+		{
+			name:    "address of another owner",
+			addrs:   addrs("192.0.2.3", "192.0.2.2"),
+			want:    addrs("192.0.2.1"),
+			wantErr: true,
+		},
+		{
+			name:    "address outside the pools",
+			addrs:   addrs("192.0.2.3", "198.51.100.1"),
+			want:    addrs("192.0.2.1"),
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testAllocator(t, "192.0.2.0/29", "2001:db8::/126")
+			if err := a.Reserve("default/a", netip.MustParseAddr("192.0.2.1")); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Reserve("default/b", netip.MustParseAddr("192.0.2.2")); err != nil {
+				t.Fatal(err)
+			}
+
+			err := a.Assign("default/a", tt.addrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Assign() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := a.Lookup("default/a"); !slices.Equal(got, tt.want) {
+				t.Errorf("Lookup() = %v, want %v", got, tt.want)
+			}
+			if got := a.Lookup("default/b"); !slices.Equal(got, addrs("192.0.2.2")) {
+				t.Errorf("Lookup() of the other owner = %v, want [192.0.2.2]", got)
+			}
+
+			// an address given up by Assign can be reserved again
+			if !tt.wantErr && !slices.Contains(tt.want, netip.MustParseAddr("192.0.2.1")) {
+				if err := a.Reserve("default/c", netip.MustParseAddr("192.0.2.1")); err != nil {
+					t.Errorf("Reserve() of a released address error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	a := testAllocator(t, "192.0.2.1-192.0.2.2")
+	if err := a.Assign("default/a", addrs("192.0.2.1", "192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Allocate("default/b"); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("Allocate() error = %v, want %v", err, ErrPoolExhausted)
+	}
+
+	a.Release("default/a")
+	// releasing an unknown owner is a no-op
+	a.Release("default/unknown")
+
+	if got := a.Lookup("default/a"); len(got) != 0 {
+		t.Errorf("Lookup() = %v, want nothing after Release", got)
+	}
+	if err := a.Assign("default/b", addrs("192.0.2.1", "192.0.2.2")); err != nil {
+		t.Errorf("Assign() after Release error = %v", err)
+	}
+}
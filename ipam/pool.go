@@ -0,0 +1,73 @@
+package ipam
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Pool is a contiguous range of addresses that VIPs can be allocated from.
+type Pool struct {
+	first netip.Addr
+	last  netip.Addr
+}
+
+// ParsePool parses a pool from either a CIDR ("192.0.2.0/24") or an
+// inclusive address range ("192.0.2.10-192.0.2.20").
+func ParsePool(s string) (*Pool, error) {
+	s = strings.TrimSpace(s)
+
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		first, err := netip.ParseAddr(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool %q: %w", s, err)
+		}
+		last, err := netip.ParseAddr(strings.TrimSpace(to))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool %q: %w", s, err)
+		}
+		if first.Is4() != last.Is4() {
+			return nil, fmt.Errorf("invalid pool %q: mixed address families", s)
+		}
+		if last.Less(first) {
+			return nil, fmt.Errorf("invalid pool %q: range end is before range start", s)
+		}
+
+		return &Pool{first: first.Unmap(), last: last.Unmap()}, nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pool %q: %w", s, err)
+	}
+	prefix = prefix.Masked()
+
+	first := prefix.Addr()
+	last := lastAddr(prefix)
+	// skip network and broadcast addresses, they are not usable as a VIP
+	if first.Is4() && prefix.Bits() < 31 {
+		first = first.Next()
+		last = last.Prev()
+	}
+
+	return &Pool{first: first, last: last}, nil
+}
+
+func (p *Pool) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.Less(p.first) && !p.last.Less(addr)
+}
+
+func (p *Pool) String() string {
+	return fmt.Sprintf("%s-%s", p.first, p.last)
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - uint(i%8))
+	}
+	addr, _ := netip.AddrFromSlice(b)
+
+	return addr
+}
@@ -0,0 +1,75 @@
+package ipam
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParsePool(t *testing.T) {
+	for _, tt := range []struct {
+		pool    string
+		want    string
+		wantErr bool
+	}{
+		// network and broadcast addresses are skipped
+		{pool: "192.0.2.0/24", want: "192.0.2.1-192.0.2.254"},
+		{pool: "192.0.2.0/30", want: "192.0.2.1-192.0.2.2"},
+		{pool: "192.0.2.7/29", want: "192.0.2.1-192.0.2.6"},
+		// point-to-point and single addresses are kept whole
+		{pool: "192.0.2.0/31", want: "192.0.2.0-192.0.2.1"},
+		{pool: "192.0.2.5/32", want: "192.0.2.5-192.0.2.5"},
+		{pool: " 192.0.2.0/28 ", want: "192.0.2.1-192.0.2.14"},
+		{pool: "192.0.2.10-192.0.2.20", want: "192.0.2.10-192.0.2.20"},
+		{pool: "192.0.2.10 - 192.0.2.10", want: "192.0.2.10-192.0.2.10"},
+		// IPv6 has no broadcast address
+		{pool: "2001:db8::/126", want: "2001:db8::-2001:db8::3"},
+		{pool: "2001:db8::/128", want: "2001:db8::-2001:db8::"},
+		{pool: "2001:db8::10-2001:db8::1f", want: "2001:db8::10-2001:db8::1f"},
+		{pool: "192.0.2.1-2001:db8::1", wantErr: true},
+		{pool: "2001:db8::1-192.0.2.1", wantErr: true},
+		{pool: "192.0.2.20-192.0.2.10", wantErr: true},
+		{pool: "192.0.2.0/33", wantErr: true},
+		{pool: "192.0.2.0", wantErr: true},
+		{pool: "192.0.2.1-", wantErr: true},
+		{pool: "", wantErr: true},
+	} {
+		t.Run(tt.pool, func(t *testing.T) {
+			pool, err := ParsePool(tt.pool)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParsePool(%q) = %s, want an error", tt.pool, pool)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePool(%q) error: %v", tt.pool, err)
+			}
+			if got := pool.String(); got != tt.want {
+				t.Errorf("ParsePool(%q) = %s, want %s", tt.pool, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolContains(t *testing.T) {
+	pool, err := ParsePool("192.0.2.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		addr string
+		want bool
+	}{
+		{"192.0.2.0", false},
+		{"192.0.2.1", true},
+		{"192.0.2.2", true},
+		{"192.0.2.3", false},
+		{"::ffff:192.0.2.1", true},
+		{"2001:db8::1", false},
+	} {
+		if got := pool.Contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
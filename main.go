@@ -1,11 +1,13 @@
 package main
 
 import (
-	"flag"
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/client"
+	"github.com/bear-san/haproxy-ccm/config"
 	"github.com/bear-san/haproxy-ccm/controllers"
+	"github.com/bear-san/haproxy-ccm/ipam"
+	"github.com/bear-san/haproxy-ccm/model"
 	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"io"
 	"k8s.io/apimachinery/pkg/util/wait"
 	cloudprovider "k8s.io/cloud-provider"
@@ -25,36 +27,100 @@ func main() {
 		panic(err)
 	}
 
-	haproxyEndpoint := flag.String("haproxy-endpoint", os.Getenv("HAPROXY_ENDPOINT"), "The endpoint of the haproxy gRPC API")
-	if *haproxyEndpoint == "" {
-		klog.Fatalf("haproxy endpoint is required")
-	}
+	fss := cliflag.NamedFlagSets{}
+	haproxyFlags := &config.Flags{}
+	haproxyFlags.AddFlags(fss.FlagSet("haproxy"))
+
+	cloudprovider.RegisterCloudProvider("haproxy", func(configReader io.Reader) (cloudprovider.Interface, error) {
+		cfg, err := config.Load(configReader)
+		if err != nil {
+			return nil, err
+		}
+
+		haproxyFlags.ApplyTo(cfg)
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid cloud config: %w", err)
+		}
+
+		if _, err := controllers.ParseBalanceAlgorithm(cfg.DefaultBalanceAlgorithm); err != nil {
+			return nil, fmt.Errorf("invalid cloud config: defaultBalanceAlgorithm: %w", err)
+		}
+		// the default also applies to TCP backends
+		if controllers.RequiresHTTPMode(cfg.DefaultBalanceAlgorithm) {
+			return nil, fmt.Errorf("invalid cloud config: defaultBalanceAlgorithm: %q requires HTTP mode, set it per Service with the %s annotation", cfg.DefaultBalanceAlgorithm, controllers.AnnotationBalanceAlgorithm)
+		}
 
-	cloudprovider.RegisterCloudProvider("haproxy", func(config io.Reader) (cloudprovider.Interface, error) {
 		// Create gRPC connection
-		conn, err := grpc.NewClient(*haproxyEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := client.Dial(cfg)
 		if err != nil {
 			return nil, err
 		}
-		
-		client := haproxyv1.NewHAProxyManagerServiceClient(conn)
-		
-		return &controllers.Provider{
-			HAProxyClient: client,
+
+		provider := &controllers.Provider{
+			HAProxyClient: haproxyv1.NewHAProxyManagerServiceClient(conn),
 			Connection:    conn,
-		}, nil
+			Options: model.Options{
+				NamingPrefix:     cfg.NamingPrefix,
+				BalanceAlgorithm: cfg.DefaultBalanceAlgorithm,
+				NodeAddressTypes: cfg.NodeAddressPreference,
+				BackendMode:      model.BackendMode(cfg.BackendMode),
+				HealthCheck:      healthCheck(cfg.HealthCheck),
+				HTTPTimeouts: model.HTTPTimeouts{
+					Request:   cfg.HTTPTimeouts.Request.Duration,
+					KeepAlive: cfg.HTTPTimeouts.KeepAlive.Duration,
+					Tunnel:    cfg.HTTPTimeouts.Tunnel.Duration,
+				},
+			},
+			ClusterID:    cfg.ClusterID,
+			IPMode:       cfg.IPMode,
+			GCReportOnly: cfg.GarbageCollection.Mode == config.GCModeReport,
+		}
+		if cfg.GarbageCollection.Mode != config.GCModeDisabled {
+			provider.GCInterval = cfg.GarbageCollection.Interval.Duration
+		}
+
+		var pools []*ipam.Pool
+		for _, item := range cfg.IPPools {
+			pool, err := ipam.ParsePool(item)
+			if err != nil {
+				return nil, err
+			}
+			pools = append(pools, pool)
+		}
+		if len(pools) > 0 {
+			provider.IPAM = ipam.NewAllocator(pools)
+		}
+
+		return provider, nil
 	})
 
 	controllerInitializers := app.DefaultInitFuncConstructors
 	controllerAliases := names.CCMControllerAliases()
 
-	fss := cliflag.NamedFlagSets{}
-
 	command := app.NewCloudControllerManagerCommand(ccmOptions, cloudInitializer, controllerInitializers, controllerAliases, fss, wait.NeverStop)
 	code := cli.Run(command)
 	os.Exit(code)
 }
 
+func healthCheck(cfg config.HealthCheckConfig) model.HealthCheck {
+	// validated by config.Validate
+	checkType, _ := model.ParseCheckType(cfg.Type)
+	if checkType == model.CheckNone {
+		return model.HealthCheck{}
+	}
+
+	return model.HealthCheck{
+		Type:         checkType,
+		Path:         cfg.Path,
+		ExpectStatus: cfg.ExpectedStatus,
+		SSL:          cfg.SSL,
+		Interval:     cfg.Interval.Duration,
+		Timeout:      cfg.Timeout.Duration,
+		Rise:         cfg.Rise,
+		Fall:         cfg.Fall,
+	}
+}
+
 func cloudInitializer(config *cloudcontrollerconfig.CompletedConfig) cloudprovider.Interface {
 	cloud, err := cloudprovider.InitCloudProvider("haproxy", config.ComponentConfig.KubeCloudShared.CloudProvider.CloudConfigFile)
 	if err != nil {
@@ -64,6 +130,10 @@ func cloudInitializer(config *cloudcontrollerconfig.CompletedConfig) cloudprovid
 		klog.Fatalf("Cloud provider is nil")
 	}
 
+	if provider, ok := cloud.(*controllers.Provider); ok {
+		provider.ClusterName = config.ComponentConfig.KubeCloudShared.ClusterName
+	}
+
 	if !cloud.HasClusterID() {
 		if config.ComponentConfig.KubeCloudShared.AllowUntaggedCloud {
 			klog.Warning("detected a cluster without a ClusterID.  A ClusterID will be required in the future.  Please tag your cluster to avoid any future issues")
@@ -0,0 +1,279 @@
+package model
+
+import (
+	"fmt"
+	"github.com/bear-san/haproxy-ccm/naming"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/utils/ptr"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Options are the provider-wide settings that shape the configuration.
+type Options struct {
+	NamingPrefix string
+	// ClusterName scopes the names to one cluster so that several clusters
+	// can share an HAProxy. Empty selects the legacy cluster-less names.
+	ClusterName      string
+	BalanceAlgorithm string
+	NodeAddressTypes []v1.NodeAddressType
+	BackendMode      BackendMode
+	HealthCheck      HealthCheck
+	// SourceRanges restricts the clients of the Service, see
+	// Frontend.SourceRanges.
+	SourceRanges []string
+	// HTTPPorts are served in HTTP mode in addition to the ports with an
+	// HTTP appProtocol.
+	HTTPPorts    []int32
+	HTTPTimeouts HTTPTimeouts
+}
+
+// BackendMode selects where a Service's traffic is sent.
+type BackendMode string
+
+const (
+	// BackendNode sends traffic to the NodePort of every node.
+	BackendNode BackendMode = "node"
+	// BackendPod sends traffic to the ready endpoints of the Service's
+	// EndpointSlices, which requires routable pod IPs.
+	BackendPod BackendMode = "pod"
+)
+
+// Build computes the desired configuration of service. addresses are the
+// VIPs the frontends bind to. nodes are only used by BackendNode and
+// endpointSlices only by BackendPod.
+func Build(service *v1.Service, nodes []*v1.Node, endpointSlices []*discoveryv1.EndpointSlice, addresses []string, opts Options) *LoadBalancer {
+	lb := NewLoadBalancer()
+	namer := opts.Namer()
+	check, checkPort := healthCheck(service, opts)
+
+	for _, port := range service.Spec.Ports {
+		if !SupportsProtocol(port.Protocol) {
+			continue
+		}
+		resourceName := namer.Port(service.UID, port)
+		mode, proto := portMode(port, opts)
+		portCheck := httpCheck(check, mode, proto)
+
+		var servers map[string]*Server
+		if opts.BackendMode == BackendPod {
+			servers = podServers(service, port, endpointSlices, namer)
+		} else {
+			servers = nodeServers(service, port, nodes, opts, namer)
+		}
+		for _, server := range servers {
+			server.Check = portCheck.Type != CheckNone
+			server.CheckPort = checkPort
+			server.Proto = proto
+		}
+
+		backend := &Backend{
+			Name:    resourceName,
+			Mode:    mode,
+			Balance: opts.BalanceAlgorithm,
+			Check:   portCheck,
+			// kube-proxy cannot keep the affinity, it only sees HAProxy
+			// as the client
+			SourcePersistence: sourcePersistence(service),
+			Servers:           servers,
+		}
+		lb.Backends[resourceName] = backend
+
+		frontend := &Frontend{
+			Name:           resourceName,
+			Mode:           mode,
+			DefaultBackend: resourceName,
+			SourceRanges:   opts.SourceRanges,
+			Binds:          map[string]*Bind{},
+		}
+		if mode == ModeHTTP {
+			frontend.ForwardedHeaders = true
+			frontend.RequestTimeout = opts.HTTPTimeouts.Request
+			frontend.KeepAliveTimeout = opts.HTTPTimeouts.KeepAlive
+			backend.TunnelTimeout = opts.HTTPTimeouts.Tunnel
+		}
+		for _, ip := range addresses {
+			bindName := namer.Bind(service.UID, port, ip)
+			frontend.Binds[bindName] = &Bind{
+				Name:    bindName,
+				Address: ip,
+				Port:    port.Port,
+			}
+		}
+		lb.Frontends[resourceName] = frontend
+	}
+
+	return lb
+}
+
+// SupportsProtocol reports whether ports of protocol can be load balanced.
+// The configurator only offers TCP and HTTP proxies, so UDP and SCTP ports
+// are left out instead of being proxied as TCP.
+func SupportsProtocol(protocol v1.Protocol) bool {
+	return protocol == v1.ProtocolTCP
+}
+
+// httpAppProtocols are the appProtocols of ports served in HTTP mode, mapped
+// to the protocol spoken to their servers.
+var httpAppProtocols = map[string]string{
+	"http":              "",
+	"kubernetes.io/h2c": "h2",
+	"kubernetes.io/ws":  "",
+}
+
+// portMode returns the mode of port and the protocol spoken to its servers.
+func portMode(port v1.ServicePort, opts Options) (Mode, string) {
+	if proto, ok := httpAppProtocols[ptr.Deref(port.AppProtocol, "")]; ok {
+		return ModeHTTP, proto
+	}
+	if slices.Contains(opts.HTTPPorts, port.Port) {
+		return ModeHTTP, ""
+	}
+
+	return ModeTCP, ""
+}
+
+// httpCheck turns the TCP check of an HTTP port into an HTTP check. Servers
+// spoken to with HTTP/2 keep the TCP check, HTTP checks use HTTP/1.1.
+func httpCheck(check HealthCheck, mode Mode, proto string) HealthCheck {
+	if mode != ModeHTTP || proto != "" || check.Type != CheckTCP {
+		return check
+	}
+
+	check.Type = CheckHTTP
+	if check.Path == "" {
+		check.Path = "/"
+	}
+
+	return check
+}
+
+// nodeServers returns a server per node with a usable address. Servers are
+// named after the node only, so a change of the node set adds or removes
+// just the servers of the nodes that changed.
+func nodeServers(service *v1.Service, port v1.ServicePort, nodes []*v1.Node, opts Options, namer *naming.Namer) map[string]*Server {
+	servers := map[string]*Server{}
+	// without a NodePort, e.g. allocateLoadBalancerNodePorts: false, the
+	// nodes cannot be used
+	if port.NodePort == 0 {
+		return servers
+	}
+
+	for _, node := range nodes {
+		nodeIp := NodeAddress(node, opts.NodeAddressTypes)
+
+		// skip if node doesn't have a usable address
+		if nodeIp == "" {
+			continue
+		}
+		serverName := namer.Server(service.UID, port, node.Name)
+		servers[serverName] = &Server{
+			Name:    serverName,
+			Address: nodeIp,
+			Port:    port.NodePort,
+		}
+	}
+
+	return servers
+}
+
+// podServers returns a server per ready endpoint of port, named after the
+// endpoint address.
+func podServers(service *v1.Service, port v1.ServicePort, endpointSlices []*discoveryv1.EndpointSlice, namer *naming.Namer) map[string]*Server {
+	servers := map[string]*Server{}
+
+	for _, slice := range endpointSlices {
+		var targetPort int32
+		for _, endpointPort := range slice.Ports {
+			if ptr.Deref(endpointPort.Name, "") == port.Name && ptr.Deref(endpointPort.Protocol, v1.ProtocolTCP) == port.Protocol {
+				targetPort = ptr.Deref(endpointPort.Port, 0)
+			}
+		}
+		if targetPort == 0 {
+			continue
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			if !ptr.Deref(endpoint.Conditions.Ready, true) || len(endpoint.Addresses) == 0 {
+				continue
+			}
+			serverName := namer.Server(service.UID, port, endpoint.Addresses[0])
+			servers[serverName] = &Server{
+				Name:    serverName,
+				Address: endpoint.Addresses[0],
+				Port:    targetPort,
+			}
+		}
+	}
+
+	return servers
+}
+
+// sourcePersistence maps the ClientIP session affinity of service.
+func sourcePersistence(service *v1.Service) time.Duration {
+	if service.Spec.SessionAffinity != v1.ServiceAffinityClientIP {
+		return 0
+	}
+
+	timeout := v1.DefaultClientIPServiceAffinitySeconds
+	if config := service.Spec.SessionAffinityConfig; config != nil && config.ClientIP != nil && config.ClientIP.TimeoutSeconds != nil {
+		timeout = *config.ClientIP.TimeoutSeconds
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
+// healthCheck returns the check of the servers and the port it is sent to.
+// With the Local traffic policy kube-proxy answers /healthz on the health
+// check NodePort with 200 only on nodes with ready local endpoints, so the
+// other nodes are taken out of rotation; only the timing of opts.HealthCheck
+// applies to it. Otherwise any node forwards the traffic, and
+// opts.HealthCheck is sent to the traffic port.
+func healthCheck(service *v1.Service, opts Options) (HealthCheck, int32) {
+	check := opts.HealthCheck
+	if check.Type == CheckNone {
+		return HealthCheck{}, 0
+	}
+
+	if opts.BackendMode != BackendPod && service.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyLocal && service.Spec.HealthCheckNodePort != 0 {
+		check.Type = CheckHTTP
+		check.Path = "/healthz"
+		check.ExpectStatus = 0
+		check.SSL = false
+		return check, service.Spec.HealthCheckNodePort
+	}
+
+	return check, 0
+}
+
+// Namer names the objects of the cluster.
+func (o Options) Namer() *naming.Namer {
+	return naming.New(o.NamingPrefix, o.ClusterName)
+}
+
+// ResourcePrefix is the common prefix of every object name of service.
+func ResourcePrefix(service *v1.Service, opts Options) string {
+	return strings.TrimSuffix(opts.Namer().ServicePrefix(service.UID), "-")
+}
+
+// LegacyResourcePrefix is the prefix service used before names were scoped
+// to a cluster. Objects named this way are adopted, and replaced, by the
+// next reconcile.
+func LegacyResourcePrefix(service *v1.Service, opts Options) string {
+	return fmt.Sprintf("%s-%s", opts.NamingPrefix, service.UID)
+}
+
+// NodeAddress returns the first node address matching the preference order.
+func NodeAddress(node *v1.Node, preference []v1.NodeAddressType) string {
+	for _, addressType := range preference {
+		for _, address := range node.Status.Addresses {
+			if address.Type == addressType {
+				return address.Address
+			}
+		}
+	}
+
+	return ""
+}
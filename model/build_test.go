@@ -0,0 +1,470 @@
+package model
+
+import (
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testUID types.UID = "0f0e0d0c-1111-2222-3333-444455556666"
+
+var testOptions = Options{
+	NamingPrefix:     "haproxy",
+	ClusterName:      "k",
+	BalanceAlgorithm: "roundrobin",
+	NodeAddressTypes: []v1.NodeAddressType{v1.NodeInternalIP},
+	BackendMode:      BackendNode,
+}
+
+func testService(ports ...v1.ServicePort) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: testUID},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: ports,
+		},
+	}
+}
+
+func testNode(name string, addresses ...v1.NodeAddress) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{Addresses: addresses},
+	}
+}
+
+func internalIP(address string) v1.NodeAddress {
+	return v1.NodeAddress{Type: v1.NodeInternalIP, Address: address}
+}
+
+func servers(backend *Backend) map[string]Server {
+	got := map[string]Server{}
+	for name, server := range backend.Servers {
+		got[name] = *server
+	}
+
+	return got
+}
+
+func TestBuildUnnamedPort(t *testing.T) {
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}
+	service := testService(port)
+	namer := testOptions.Namer()
+	name := namer.Port(testUID, port)
+
+	lb := Build(service, []*v1.Node{testNode("node-1", internalIP("10.0.0.1"))}, nil, []string{"192.0.2.1"}, testOptions)
+
+	if got := slices.Collect(maps.Keys(lb.Frontends)); !slices.Equal(got, []string{name}) {
+		t.Fatalf("frontends = %q, want %q", got, name)
+	}
+	frontend := lb.Frontends[name]
+	if frontend.Mode != ModeTCP || frontend.DefaultBackend != name {
+		t.Errorf("frontend = %+v, want TCP mode with backend %q", frontend, name)
+	}
+	bindName := namer.Bind(testUID, port, "192.0.2.1")
+	if bind := frontend.Binds[bindName]; bind == nil || *bind != (Bind{Name: bindName, Address: "192.0.2.1", Port: 80}) {
+		t.Errorf("binds = %+v, want %q on 192.0.2.1:80", frontend.Binds, bindName)
+	}
+
+	serverName := namer.Server(testUID, port, "node-1")
+	want := map[string]Server{serverName: {Name: serverName, Address: "10.0.0.1", Port: 30080}}
+	if got := servers(lb.Backends[name]); !maps.Equal(got, want) {
+		t.Errorf("servers = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildSkipsUDPOnSamePort(t *testing.T) {
+	tcp := v1.ServicePort{Name: "dns-tcp", Protocol: v1.ProtocolTCP, Port: 53, NodePort: 30053}
+	udp := v1.ServicePort{Name: "dns-udp", Protocol: v1.ProtocolUDP, Port: 53, NodePort: 30053}
+	service := testService(tcp, udp)
+	name := testOptions.Namer().Port(testUID, tcp)
+
+	lb := Build(service, []*v1.Node{testNode("node-1", internalIP("10.0.0.1"))}, nil, []string{"192.0.2.1"}, testOptions)
+
+	if got := slices.Collect(maps.Keys(lb.Frontends)); !slices.Equal(got, []string{name}) {
+		t.Errorf("frontends = %q, want only %q", got, name)
+	}
+	if got := slices.Collect(maps.Keys(lb.Backends)); !slices.Equal(got, []string{name}) {
+		t.Errorf("backends = %q, want only %q", got, name)
+	}
+	if len(lb.Frontends[name].Binds) != 1 {
+		t.Errorf("binds = %+v, want one bind", lb.Frontends[name].Binds)
+	}
+}
+
+func TestBuildWithoutNodePort(t *testing.T) {
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80}
+	service := testService(port)
+	name := testOptions.Namer().Port(testUID, port)
+
+	lb := Build(service, []*v1.Node{testNode("node-1", internalIP("10.0.0.1"))}, nil, []string{"192.0.2.1"}, testOptions)
+
+	backend := lb.Backends[name]
+	if backend == nil {
+		t.Fatalf("backends = %q, want %q", slices.Collect(maps.Keys(lb.Backends)), name)
+	}
+	if len(backend.Servers) != 0 {
+		t.Errorf("servers = %+v, want none without a NodePort", servers(backend))
+	}
+}
+
+func TestBuildSkipsNodesWithoutUsableAddress(t *testing.T) {
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}
+	service := testService(port)
+	namer := testOptions.Namer()
+	nodes := []*v1.Node{
+		testNode("node-1", internalIP("10.0.0.1")),
+		testNode("node-2"),
+		testNode("node-3", v1.NodeAddress{Type: v1.NodeExternalIP, Address: "198.51.100.3"}),
+		testNode("node-4", v1.NodeAddress{Type: v1.NodeHostName, Address: "node-4"}, internalIP("10.0.0.4")),
+	}
+
+	lb := Build(service, nodes, nil, []string{"192.0.2.1"}, testOptions)
+
+	node1 := namer.Server(testUID, port, "node-1")
+	node4 := namer.Server(testUID, port, "node-4")
+	want := map[string]Server{
+		node1: {Name: node1, Address: "10.0.0.1", Port: 30080},
+		node4: {Name: node4, Address: "10.0.0.4", Port: 30080},
+	}
+	if got := servers(lb.Backends[namer.Port(testUID, port)]); !maps.Equal(got, want) {
+		t.Errorf("servers = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildPodModeSkipsUnreadyEndpoints(t *testing.T) {
+	port := v1.ServicePort{Name: "http", Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}
+	service := testService(port)
+	namer := testOptions.Namer()
+	opts := testOptions
+	opts.BackendMode = BackendPod
+
+	endpointSlices := []*discoveryv1.EndpointSlice{
+		{
+			Ports: []discoveryv1.EndpointPort{
+				{Name: ptr.To("metrics"), Protocol: ptr.To(v1.ProtocolTCP), Port: ptr.To[int32](9090)},
+				{Name: ptr.To("http"), Protocol: ptr.To(v1.ProtocolTCP), Port: ptr.To[int32](8080)},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.244.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)}},
+				{Addresses: []string{"10.244.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(false)}},
+				// a nil condition means ready
+				{Addresses: []string{"10.244.0.3"}},
+				{Addresses: nil, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)}},
+			},
+		},
+		{
+			// no matching port
+			Ports:     []discoveryv1.EndpointPort{{Name: ptr.To("metrics"), Port: ptr.To[int32](9090)}},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"10.244.0.4"}}},
+		},
+	}
+
+	lb := Build(service, []*v1.Node{testNode("node-1", internalIP("10.0.0.1"))}, endpointSlices, []string{"192.0.2.1"}, opts)
+
+	pod1 := namer.Server(testUID, port, "10.244.0.1")
+	pod3 := namer.Server(testUID, port, "10.244.0.3")
+	want := map[string]Server{
+		pod1: {Name: pod1, Address: "10.244.0.1", Port: 8080},
+		pod3: {Name: pod3, Address: "10.244.0.3", Port: 8080},
+	}
+	if got := servers(lb.Backends[namer.Port(testUID, port)]); !maps.Equal(got, want) {
+		t.Errorf("servers = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildHealthCheck(t *testing.T) {
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}
+	tcpCheck := HealthCheck{Type: CheckTCP, Interval: 2 * time.Second, Rise: 2, Fall: 3}
+	httpCheck := HealthCheck{Type: CheckHTTP, Path: "/ready", ExpectStatus: 204, SSL: true, Interval: 2 * time.Second}
+
+	for _, tt := range []struct {
+		name          string
+		policy        v1.ServiceExternalTrafficPolicy
+		healthPort    int32
+		backendMode   BackendMode
+		check         HealthCheck
+		wantCheck     HealthCheck
+		wantServer    bool
+		wantCheckPort int32
+	}{
+		{
+			name:       "cluster policy checks the traffic port",
+			policy:     v1.ServiceExternalTrafficPolicyCluster,
+			check:      tcpCheck,
+			wantCheck:  tcpCheck,
+			wantServer: true,
+		},
+		{
+			name:          "local policy checks /healthz on the health check NodePort",
+			policy:        v1.ServiceExternalTrafficPolicyLocal,
+			healthPort:    32000,
+			check:         tcpCheck,
+			wantCheck:     HealthCheck{Type: CheckHTTP, Path: "/healthz", Interval: 2 * time.Second, Rise: 2, Fall: 3},
+			wantServer:    true,
+			wantCheckPort: 32000,
+		},
+		{
+			name:          "local policy replaces an HTTP check",
+			policy:        v1.ServiceExternalTrafficPolicyLocal,
+			healthPort:    32000,
+			check:         httpCheck,
+			wantCheck:     HealthCheck{Type: CheckHTTP, Path: "/healthz", Interval: 2 * time.Second},
+			wantServer:    true,
+			wantCheckPort: 32000,
+		},
+		{
+			name:       "local policy without a health check NodePort",
+			policy:     v1.ServiceExternalTrafficPolicyLocal,
+			check:      tcpCheck,
+			wantCheck:  tcpCheck,
+			wantServer: true,
+		},
+		{
+			name:        "pod backends are checked directly",
+			policy:      v1.ServiceExternalTrafficPolicyLocal,
+			healthPort:  32000,
+			backendMode: BackendPod,
+			check:       httpCheck,
+			wantCheck:   httpCheck,
+			wantServer:  true,
+		},
+		{
+			name:       "none",
+			policy:     v1.ServiceExternalTrafficPolicyCluster,
+			check:      HealthCheck{Type: CheckNone, Interval: 2 * time.Second},
+			wantCheck:  HealthCheck{},
+			wantServer: false,
+		},
+		{
+			name:       "none with local policy",
+			policy:     v1.ServiceExternalTrafficPolicyLocal,
+			healthPort: 32000,
+			check:      HealthCheck{Type: CheckNone},
+			wantCheck:  HealthCheck{},
+			wantServer: false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			service := testService(port)
+			service.Spec.ExternalTrafficPolicy = tt.policy
+			service.Spec.HealthCheckNodePort = tt.healthPort
+			opts := testOptions
+			opts.HealthCheck = tt.check
+			var endpointSlices []*discoveryv1.EndpointSlice
+			if tt.backendMode == BackendPod {
+				opts.BackendMode = BackendPod
+				endpointSlices = []*discoveryv1.EndpointSlice{{
+					Ports:     []discoveryv1.EndpointPort{{Port: ptr.To[int32](8080)}},
+					Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"10.244.0.1"}}},
+				}}
+			}
+
+			lb := Build(service, []*v1.Node{testNode("node-1", internalIP("10.0.0.1"))}, endpointSlices, []string{"192.0.2.1"}, opts)
+
+			backend := lb.Backends[opts.Namer().Port(testUID, port)]
+			if backend.Check != tt.wantCheck {
+				t.Errorf("check = %+v, want %+v", backend.Check, tt.wantCheck)
+			}
+			if len(backend.Servers) != 1 {
+				t.Fatalf("servers = %+v, want one", servers(backend))
+			}
+			for _, server := range backend.Servers {
+				if server.Check != tt.wantServer || server.CheckPort != tt.wantCheckPort {
+					t.Errorf("server check = %v on port %d, want %v on port %d", server.Check, server.CheckPort, tt.wantServer, tt.wantCheckPort)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildSourcePersistence(t *testing.T) {
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}
+
+	for _, tt := range []struct {
+		name     string
+		affinity v1.ServiceAffinity
+		config   *v1.SessionAffinityConfig
+		want     time.Duration
+	}{
+		{name: "no affinity", affinity: v1.ServiceAffinityNone},
+		{name: "unset affinity"},
+		{name: "client IP", affinity: v1.ServiceAffinityClientIP, want: 3 * time.Hour},
+		{
+			name:     "client IP without timeout",
+			affinity: v1.ServiceAffinityClientIP,
+			config:   &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{}},
+			want:     3 * time.Hour,
+		},
+		{
+			name:     "client IP with timeout",
+			affinity: v1.ServiceAffinityClientIP,
+			config:   &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: ptr.To[int32](60)}},
+			want:     time.Minute,
+		},
+		{
+			name:     "timeout without client IP affinity",
+			affinity: v1.ServiceAffinityNone,
+			config:   &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: ptr.To[int32](60)}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			service := testService(port)
+			service.Spec.SessionAffinity = tt.affinity
+			service.Spec.SessionAffinityConfig = tt.config
+
+			lb := Build(service, nil, nil, []string{"192.0.2.1"}, testOptions)
+
+			if got := lb.Backends[testOptions.Namer().Port(testUID, port)].SourcePersistence; got != tt.want {
+				t.Errorf("SourcePersistence = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSourceRanges(t *testing.T) {
+	web := v1.ServicePort{Name: "web", Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080, AppProtocol: ptr.To("http")}
+	db := v1.ServicePort{Name: "db", Protocol: v1.ProtocolTCP, Port: 5432, NodePort: 30432}
+	service := testService(web, db)
+
+	for _, ranges := range [][]string{
+		nil,
+		{"10.0.0.0/8", "2001:db8::/32"},
+	} {
+		opts := testOptions
+		opts.SourceRanges = ranges
+
+		lb := Build(service, nil, nil, []string{"192.0.2.1", "2001:db8::1"}, opts)
+
+		if len(lb.Frontends) != 2 {
+			t.Fatalf("frontends = %q, want two", slices.Collect(maps.Keys(lb.Frontends)))
+		}
+		for name, frontend := range lb.Frontends {
+			if !slices.Equal(frontend.SourceRanges, ranges) {
+				t.Errorf("frontend %s source ranges = %q, want %q", name, frontend.SourceRanges, ranges)
+			}
+		}
+	}
+}
+
+func TestBuildHTTPMode(t *testing.T) {
+	tcpCheck := HealthCheck{Type: CheckTCP, Interval: 2 * time.Second}
+	timeouts := HTTPTimeouts{Request: 10 * time.Second, KeepAlive: 5 * time.Second, Tunnel: time.Hour}
+
+	for _, tt := range []struct {
+		name        string
+		appProtocol *string
+		httpPorts   []int32
+		check       HealthCheck
+		wantMode    Mode
+		wantProto   string
+		wantCheck   HealthCheck
+	}{
+		{
+			name:      "tcp",
+			check:     tcpCheck,
+			wantMode:  ModeTCP,
+			wantCheck: tcpCheck,
+		},
+		{
+			name:        "unknown appProtocol",
+			appProtocol: ptr.To("kubernetes.io/wss"),
+			check:       tcpCheck,
+			wantMode:    ModeTCP,
+			wantCheck:   tcpCheck,
+		},
+		{
+			name:        "http",
+			appProtocol: ptr.To("http"),
+			check:       tcpCheck,
+			wantMode:    ModeHTTP,
+			wantCheck:   HealthCheck{Type: CheckHTTP, Path: "/", Interval: 2 * time.Second},
+		},
+		{
+			name:        "ws",
+			appProtocol: ptr.To("kubernetes.io/ws"),
+			check:       tcpCheck,
+			wantMode:    ModeHTTP,
+			wantCheck:   HealthCheck{Type: CheckHTTP, Path: "/", Interval: 2 * time.Second},
+		},
+		{
+			name:        "h2c keeps the tcp check",
+			appProtocol: ptr.To("kubernetes.io/h2c"),
+			check:       tcpCheck,
+			wantMode:    ModeHTTP,
+			wantProto:   "h2",
+			wantCheck:   tcpCheck,
+		},
+		{
+			name:      "http ports",
+			httpPorts: []int32{8080, 80},
+			check:     tcpCheck,
+			wantMode:  ModeHTTP,
+			wantCheck: HealthCheck{Type: CheckHTTP, Path: "/", Interval: 2 * time.Second},
+		},
+		{
+			name:      "other http ports",
+			httpPorts: []int32{8080},
+			check:     tcpCheck,
+			wantMode:  ModeTCP,
+			wantCheck: tcpCheck,
+		},
+		{
+			name:        "http check keeps its path",
+			appProtocol: ptr.To("http"),
+			check:       HealthCheck{Type: CheckHTTP, Path: "/ready"},
+			wantMode:    ModeHTTP,
+			wantCheck:   HealthCheck{Type: CheckHTTP, Path: "/ready"},
+		},
+		{
+			name:        "none stays none",
+			appProtocol: ptr.To("http"),
+			check:       HealthCheck{Type: CheckNone},
+			wantMode:    ModeHTTP,
+			wantCheck:   HealthCheck{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			port := v1.ServicePort{Name: "web", Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080, AppProtocol: tt.appProtocol}
+			service := testService(port)
+			opts := testOptions
+			opts.HealthCheck = tt.check
+			opts.HTTPPorts = tt.httpPorts
+			opts.HTTPTimeouts = timeouts
+			name := opts.Namer().Port(testUID, port)
+
+			lb := Build(service, []*v1.Node{testNode("node-1", internalIP("10.0.0.1"))}, nil, []string{"192.0.2.1"}, opts)
+
+			frontend, backend := lb.Frontends[name], lb.Backends[name]
+			if frontend.Mode != tt.wantMode || backend.Mode != tt.wantMode {
+				t.Errorf("mode = %s/%s, want %s", frontend.Mode, backend.Mode, tt.wantMode)
+			}
+			if backend.Check != tt.wantCheck {
+				t.Errorf("check = %+v, want %+v", backend.Check, tt.wantCheck)
+			}
+			for _, server := range backend.Servers {
+				if server.Proto != tt.wantProto {
+					t.Errorf("server proto = %q, want %q", server.Proto, tt.wantProto)
+				}
+			}
+
+			http := tt.wantMode == ModeHTTP
+			if frontend.ForwardedHeaders != http {
+				t.Errorf("ForwardedHeaders = %v, want %v", frontend.ForwardedHeaders, http)
+			}
+			want := HTTPTimeouts{}
+			if http {
+				want = timeouts
+			}
+			if got := (HTTPTimeouts{Request: frontend.RequestTimeout, KeepAlive: frontend.KeepAliveTimeout, Tunnel: backend.TunnelTimeout}); got != want {
+				t.Errorf("timeouts = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
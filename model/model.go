@@ -0,0 +1,145 @@
+// Package model describes the HAProxy configuration of a Service
+// independently of the configurator API.
+package model
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+type Mode string
+
+const (
+	ModeTCP  Mode = "tcp"
+	ModeHTTP Mode = "http"
+)
+
+// LoadBalancer is every HAProxy object that belongs to one Service, keyed
+// by object name.
+type LoadBalancer struct {
+	Frontends map[string]*Frontend
+	Backends  map[string]*Backend
+}
+
+type Frontend struct {
+	Name           string
+	Mode           Mode
+	DefaultBackend string
+	// SourceRanges are the sorted CIDRs connections are accepted from. Empty
+	// accepts every connection.
+	SourceRanges []string
+	// ForwardedHeaders adds X-Forwarded-For and X-Forwarded-Proto to the
+	// requests of an HTTP frontend.
+	ForwardedHeaders bool
+	// RequestTimeout and KeepAliveTimeout are the HTTP request and
+	// keep-alive timeouts, zero leaves the HAProxy defaults.
+	RequestTimeout   time.Duration
+	KeepAliveTimeout time.Duration
+	Binds            map[string]*Bind
+	// Unmanaged are the binds of an observed frontend created by someone
+	// else. A frontend holding any is never deleted.
+	Unmanaged []string
+}
+
+type Bind struct {
+	Name    string
+	Address string
+	Port    int32
+}
+
+type Backend struct {
+	Name    string
+	Mode    Mode
+	Balance string
+	Check   HealthCheck
+	// SourcePersistence is how long a client is sent to the server it was
+	// first sent to, identified by its source address. Zero disables it.
+	SourcePersistence time.Duration
+	// TunnelTimeout bounds the inactivity of upgraded connections such as
+	// WebSockets, zero leaves the HAProxy default.
+	TunnelTimeout time.Duration
+	Servers       map[string]*Server
+	// Unmanaged are the servers of an observed backend created by someone
+	// else. A backend holding any is never deleted.
+	Unmanaged []string
+}
+
+type Server struct {
+	Name    string
+	Address string
+	Port    int32
+	// Check enables the health check of the backend on the server.
+	// CheckPort overrides the port it is sent to.
+	Check     bool
+	CheckPort int32
+	// Proto is "h2" to speak HTTP/2 without TLS to the server, empty
+	// leaves the protocol to HAProxy.
+	Proto string
+}
+
+type CheckType string
+
+const (
+	CheckNone CheckType = ""
+	CheckTCP  CheckType = "tcp"
+	CheckHTTP CheckType = "http"
+)
+
+// ParseCheckType parses "tcp", "http" or "none".
+func ParseCheckType(value string) (CheckType, error) {
+	switch value {
+	case "none":
+		return CheckNone, nil
+	case string(CheckTCP), string(CheckHTTP):
+		return CheckType(value), nil
+	default:
+		return "", fmt.Errorf("must be \"tcp\", \"http\" or \"none\", got %q", value)
+	}
+}
+
+// HTTPTimeouts are the timeouts of ports in HTTP mode, see
+// Frontend.RequestTimeout and Backend.TunnelTimeout.
+type HTTPTimeouts struct {
+	Request   time.Duration
+	KeepAlive time.Duration
+	Tunnel    time.Duration
+}
+
+// HealthCheck is how the servers of a backend are checked. Zero values leave
+// the HAProxy defaults in place.
+type HealthCheck struct {
+	Type CheckType
+	// Path is the URI requested by HTTP checks, and ExpectStatus the status
+	// they expect instead of any 2xx or 3xx.
+	Path         string
+	ExpectStatus int32
+	// SSL sends the check over TLS.
+	SSL      bool
+	Interval time.Duration
+	Timeout  time.Duration
+	// Rise and Fall are the consecutive successful and failed checks that
+	// take a server in and out of rotation.
+	Rise int32
+	Fall int32
+}
+
+func NewLoadBalancer() *LoadBalancer {
+	return &LoadBalancer{
+		Frontends: map[string]*Frontend{},
+		Backends:  map[string]*Backend{},
+	}
+}
+
+// SameSettings reports whether f and other only differ in their binds.
+func (f *Frontend) SameSettings(other *Frontend) bool {
+	return f.Mode == other.Mode && f.DefaultBackend == other.DefaultBackend &&
+		slices.Equal(f.SourceRanges, other.SourceRanges) && f.ForwardedHeaders == other.ForwardedHeaders &&
+		f.RequestTimeout == other.RequestTimeout && f.KeepAliveTimeout == other.KeepAliveTimeout
+}
+
+// SameSettings reports whether b and other only differ in their servers.
+func (b *Backend) SameSettings(other *Backend) bool {
+	return b.Mode == other.Mode && b.Balance == other.Balance && b.Check == other.Check &&
+		b.SourcePersistence == other.SourcePersistence && b.TunnelTimeout == other.TunnelTimeout
+}
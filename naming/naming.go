@@ -0,0 +1,178 @@
+// Package naming builds the names of the HAProxy objects of a Service and
+// maps them back to the Service and port they belong to.
+//
+// Frontends and backends are named per Service port, binds per port and
+// address, servers per port and target:
+//
+//	<namingPrefix>-<cluster>-<service UID>-<protocol>-<port>
+//	<namingPrefix>-<cluster>-<service UID>-<protocol>-<port>-<address>
+//	<namingPrefix>-<cluster>-<service UID>-<protocol>-<port>-srv-<target>
+//
+// Colons of IPv6 addresses are replaced by underscores, and targets too long
+// to fit are replaced by a hash.
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxLength bounds every name built by a Namer.
+const MaxLength = 127
+
+// maxPrefixLength bounds the cluster prefix including its separator. The
+// cluster is replaced by a hash when the prefix would be longer, which keeps
+// the longest name, an IPv6 bind of an SCTP port, within MaxLength.
+const maxPrefixLength = 32
+
+// serverSegment separates the port from the target in server names. No
+// address starts with it.
+const serverSegment = "srv-"
+
+var (
+	// invalidPattern matches the characters not allowed in object names.
+	invalidPattern = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	uidPattern     = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+)
+
+// Name identifies the Service port an object belongs to. Address is only
+// set for binds and Server only for servers.
+type Name struct {
+	UID      types.UID
+	Protocol v1.Protocol
+	Port     int32
+	Address  string
+	Server   string
+}
+
+type Namer struct {
+	prefix string
+}
+
+// New returns a Namer for the objects of one cluster. An empty clusterName
+// leaves the cluster out of the names.
+func New(namingPrefix string, clusterName string) *Namer {
+	if clusterName == "" {
+		return &Namer{prefix: namingPrefix + "-"}
+	}
+
+	prefix := fmt.Sprintf("%s-%s-", namingPrefix, invalidPattern.ReplaceAllString(clusterName, "-"))
+	if len(prefix) > maxPrefixLength {
+		prefix = fmt.Sprintf("%s-%s-", namingPrefix, hash(clusterName))
+	}
+
+	return &Namer{prefix: prefix}
+}
+
+// ServicePrefix is the common prefix of every name of the Service with uid,
+// including the trailing separator.
+func (n *Namer) ServicePrefix(uid types.UID) string {
+	return n.prefix + string(uid) + "-"
+}
+
+// Port names the frontend and backend of port.
+func (n *Namer) Port(uid types.UID, port v1.ServicePort) string {
+	return fmt.Sprintf("%s%s-%d", n.ServicePrefix(uid), strings.ToLower(string(port.Protocol)), port.Port)
+}
+
+// Bind names the bind of port on address.
+func (n *Namer) Bind(uid types.UID, port v1.ServicePort, address string) string {
+	return n.Port(uid, port) + "-" + strings.ReplaceAll(address, ":", "_")
+}
+
+// Server names the server of port that sends traffic to target.
+func (n *Namer) Server(uid types.UID, port v1.ServicePort, target string) string {
+	name := n.Port(uid, port) + "-" + serverSegment
+	target = invalidPattern.ReplaceAllString(target, "-")
+	if len(name)+len(target) > MaxLength {
+		target = hash(target)
+	}
+
+	return name + target
+}
+
+// Parse maps a name built by n back to its Service port. It reports false
+// for names of other clusters and names not built by a Namer.
+func (n *Namer) Parse(name string) (Name, bool) {
+	rest, ok := strings.CutPrefix(name, n.prefix)
+	if !ok {
+		return Name{}, false
+	}
+
+	uid := uidPattern.FindString(rest)
+	if uid == "" {
+		return Name{}, false
+	}
+	rest, ok = strings.CutPrefix(rest[len(uid):], "-")
+	if !ok {
+		return Name{}, false
+	}
+
+	segments := strings.SplitN(rest, "-", 3)
+	if len(segments) < 2 {
+		return Name{}, false
+	}
+
+	var protocol v1.Protocol
+	switch segments[0] {
+	case "tcp":
+		protocol = v1.ProtocolTCP
+	case "udp":
+		protocol = v1.ProtocolUDP
+	case "sctp":
+		protocol = v1.ProtocolSCTP
+	default:
+		return Name{}, false
+	}
+
+	port, err := strconv.ParseInt(segments[1], 10, 32)
+	if err != nil || port <= 0 || strconv.FormatInt(port, 10) != segments[1] {
+		return Name{}, false
+	}
+
+	parsed := Name{UID: types.UID(uid), Protocol: protocol, Port: int32(port)}
+	switch {
+	case len(segments) == 2:
+	case strings.HasPrefix(segments[2], serverSegment):
+		parsed.Server = strings.TrimPrefix(segments[2], serverSegment)
+		if parsed.Server == "" {
+			return Name{}, false
+		}
+	default:
+		address, err := netip.ParseAddr(strings.ReplaceAll(segments[2], "_", ":"))
+		if err != nil {
+			return Name{}, false
+		}
+		parsed.Address = address.String()
+	}
+
+	return parsed, true
+}
+
+// ParseLegacyUID returns the Service UID of a name built before names were
+// scoped to a cluster, <namingPrefix>-<service UID>-<suffix>.
+func ParseLegacyUID(namingPrefix string, name string) (types.UID, bool) {
+	rest, ok := strings.CutPrefix(name, namingPrefix+"-")
+	if !ok {
+		return "", false
+	}
+
+	uid := uidPattern.FindString(rest)
+	if uid == "" || !strings.HasPrefix(rest[len(uid):], "-") {
+		return "", false
+	}
+
+	return types.UID(uid), true
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,173 @@
+package naming
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"testing"
+)
+
+const testUID types.UID = "0f0e0d0c-1111-2222-3333-444455556666"
+
+func TestRoundTrip(t *testing.T) {
+	longNode := strings.Repeat("node", 40)
+	longCluster := strings.Repeat("cluster", 10)
+	tcp := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80}
+	sctp := v1.ServicePort{Protocol: v1.ProtocolSCTP, Port: 65535}
+
+	for _, tt := range []struct {
+		name    string
+		cluster string
+		build   func(n *Namer) string
+		want    Name
+	}{
+		{
+			name:  "port",
+			build: func(n *Namer) string { return n.Port(testUID, tcp) },
+			want:  Name{UID: testUID, Protocol: v1.ProtocolTCP, Port: 80},
+		},
+		{
+			name:  "ipv4 bind",
+			build: func(n *Namer) string { return n.Bind(testUID, tcp, "192.0.2.1") },
+			want:  Name{UID: testUID, Protocol: v1.ProtocolTCP, Port: 80, Address: "192.0.2.1"},
+		},
+		{
+			name:  "ipv6 bind",
+			build: func(n *Namer) string { return n.Bind(testUID, sctp, "2001:db8::1") },
+			want:  Name{UID: testUID, Protocol: v1.ProtocolSCTP, Port: 65535, Address: "2001:db8::1"},
+		},
+		{
+			name:  "server",
+			build: func(n *Namer) string { return n.Server(testUID, tcp, "node-1") },
+			want:  Name{UID: testUID, Protocol: v1.ProtocolTCP, Port: 80, Server: "node-1"},
+		},
+		{
+			name:  "server of pod",
+			build: func(n *Namer) string { return n.Server(testUID, tcp, "fd00::5") },
+			want:  Name{UID: testUID, Protocol: v1.ProtocolTCP, Port: 80, Server: "fd00--5"},
+		},
+		{
+			name:  "server of long node name",
+			build: func(n *Namer) string { return n.Server(testUID, tcp, longNode) },
+			want:  Name{UID: testUID, Protocol: v1.ProtocolTCP, Port: 80, Server: hash(longNode)},
+		},
+		{
+			name:    "long cluster name",
+			cluster: longCluster,
+			build:   func(n *Namer) string { return n.Bind(testUID, sctp, "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff") },
+			want:    Name{UID: testUID, Protocol: v1.ProtocolSCTP, Port: 65535, Address: "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		},
+		{
+			name:    "long cluster name and node name",
+			cluster: longCluster,
+			build:   func(n *Namer) string { return n.Server(testUID, sctp, longNode) },
+			want:    Name{UID: testUID, Protocol: v1.ProtocolSCTP, Port: 65535, Server: hash(longNode)},
+		},
+		{
+			name:    "cluster name with invalid characters",
+			cluster: "prod/eu west",
+			build:   func(n *Namer) string { return n.Port(testUID, tcp) },
+			want:    Name{UID: testUID, Protocol: v1.ProtocolTCP, Port: 80},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := tt.cluster
+			if cluster == "" {
+				cluster = "k"
+			}
+			n := New("haproxy", cluster)
+
+			name := tt.build(n)
+			if len(name) > MaxLength {
+				t.Errorf("len(%q) = %d, want at most %d", name, len(name), MaxLength)
+			}
+			got, ok := n.Parse(name)
+			if !ok {
+				t.Fatalf("Parse(%q) failed", name)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOtherCluster(t *testing.T) {
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80}
+	ours := New("haproxy", "k")
+
+	for _, other := range []*Namer{
+		New("haproxy", "other"),
+		New("haproxy", "k2"),
+		New("haproxy", ""),
+		New("lb", "k"),
+	} {
+		for _, name := range []string{
+			other.Port(testUID, port),
+			other.Bind(testUID, port, "192.0.2.1"),
+			other.Server(testUID, port, "node-1"),
+		} {
+			if parsed, ok := ours.Parse(name); ok {
+				t.Errorf("Parse(%q) = %+v, want no match", name, parsed)
+			}
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	n := New("haproxy", "k")
+	prefix := "haproxy-k-" + string(testUID)
+
+	for _, name := range []string{
+		"",
+		prefix,
+		prefix + "-",
+		prefix + "-tcp",
+		prefix + "-tcp-",
+		prefix + "-http-80",
+		prefix + "-tcp-0",
+		prefix + "-tcp-080",
+		prefix + "-tcp-80-srv-",
+		prefix + "-tcp-80-manual",
+		"haproxy-k-0f0e0d0c-1111-2222-3333-44445555666-tcp-80",
+	} {
+		if parsed, ok := n.Parse(name); ok {
+			t.Errorf("Parse(%q) = %+v, want no match", name, parsed)
+		}
+	}
+}
+
+func TestLongClusterNameIsHashed(t *testing.T) {
+	a := New("haproxy", strings.Repeat("a", 40))
+	b := New("haproxy", strings.Repeat("a", 39)+"b")
+
+	if len(a.prefix) > maxPrefixLength {
+		t.Errorf("len(%q) = %d, want at most %d", a.prefix, len(a.prefix), maxPrefixLength)
+	}
+	if a.prefix == b.prefix {
+		t.Errorf("clusters %q and %q share the prefix %q", strings.Repeat("a", 40), strings.Repeat("a", 39)+"b", a.prefix)
+	}
+}
+
+func TestParseLegacyUID(t *testing.T) {
+	n := New("haproxy", "k")
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80}
+
+	for _, tt := range []struct {
+		name string
+		want types.UID
+	}{
+		{"haproxy-" + string(testUID) + "-tcp-80", testUID},
+		{"haproxy-" + string(testUID) + "-tcp-80-192.0.2.1", testUID},
+		{"haproxy-" + string(testUID), ""},
+		{"lb-" + string(testUID) + "-tcp-80", ""},
+		{n.Port(testUID, port), ""},
+		{New("haproxy", "kubernetes").Port(testUID, port), ""},
+		{"stats", ""},
+	} {
+		uid, ok := ParseLegacyUID("haproxy", tt.name)
+		if uid != tt.want || ok != (tt.want != "") {
+			t.Errorf("ParseLegacyUID(%q) = %q, %v, want %q", tt.name, uid, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Package transaction applies changes to HAProxy inside configurator
+// transactions.
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// closeTimeout bounds CloseTransaction, which also runs after ctx expired.
+const closeTimeout = 10 * time.Second
+
+// DefaultBackoff is used between attempts after a version conflict.
+var DefaultBackoff = wait.Backoff{
+	Duration: 200 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.2,
+	Steps:    5,
+}
+
+// Step is a single mutating call made inside a transaction.
+type Step struct {
+	Name string
+	// Group identifies related steps, e.g. those of one Service port, so
+	// that a caller can tell which of them failed.
+	Group string
+	Run   func(ctx context.Context, transactionID string) error
+}
+
+// Plan returns the steps to apply to the running configuration. It is called
+// again before every retry so that it can look at the configuration another
+// writer just committed.
+type Plan func(ctx context.Context) ([]Step, error)
+
+// StepError reports which step of a transaction failed.
+type StepError struct {
+	Step  string
+	Group string
+	Err   error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+type Runner struct {
+	Client  haproxyv1.HAProxyManagerServiceClient
+	Backoff wait.Backoff
+}
+
+func NewRunner(client haproxyv1.HAProxyManagerServiceClient) *Runner {
+	return &Runner{
+		Client:  client,
+		Backoff: DefaultBackoff,
+	}
+}
+
+// Run applies the steps of plan in one transaction. The transaction is
+// always closed when a step fails, and the whole plan is retried with a
+// fresh version when the commit loses against a concurrent writer. No
+// transaction is opened when the plan is empty.
+func (r *Runner) Run(ctx context.Context, plan Plan) error {
+	var lastErr error
+
+	err := wait.ExponentialBackoffWithContext(ctx, r.Backoff, func(ctx context.Context) (bool, error) {
+		lastErr = r.runOnce(ctx, plan)
+		if lastErr == nil {
+			return true, nil
+		}
+		if IsConflict(lastErr) {
+			klog.Warningf("HAProxy configuration changed concurrently, retrying: %v", lastErr.Error())
+			return false, nil
+		}
+
+		return false, lastErr
+	})
+	if err != nil && lastErr != nil && !errors.Is(err, lastErr) {
+		// retries exhausted or ctx done, the last attempt tells why
+		return fmt.Errorf("%w (%v)", lastErr, err)
+	}
+
+	return err
+}
+
+func (r *Runner) runOnce(ctx context.Context, plan Plan) error {
+	// the version is read before the plan, so that a commit made after the
+	// plan read the configuration fails the transaction as a conflict
+	versionResp, err := r.Client.GetVersion(ctx, &haproxyv1.GetVersionRequest{})
+	if err != nil {
+		klog.Errorf("get current version error: %v", err.Error())
+		return &StepError{Step: "get current version", Err: err}
+	}
+
+	steps, err := plan(ctx)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	transactionResp, err := r.Client.CreateTransaction(ctx, &haproxyv1.CreateTransactionRequest{
+		Version: versionResp.Version,
+	})
+	if err != nil {
+		klog.Errorf("create transaction error: %v", err.Error())
+		return &StepError{Step: "create transaction", Err: err}
+	}
+	transactionID := transactionResp.Transaction.Id
+
+	for _, step := range steps {
+		klog.V(4).Infof("HAProxy transaction %s: %s", transactionID, step.Name)
+		if err := step.Run(ctx, transactionID); err != nil {
+			klog.Errorf("%s error: %v", step.Name, err.Error())
+			r.close(ctx, transactionID)
+			return &StepError{Step: step.Name, Group: step.Group, Err: err}
+		}
+	}
+
+	if _, err := r.Client.CommitTransaction(ctx, &haproxyv1.CommitTransactionRequest{
+		TransactionId: transactionID,
+	}); err != nil {
+		klog.Errorf("commit transaction error: %v", err.Error())
+		r.close(ctx, transactionID)
+		return &StepError{Step: "commit transaction", Err: err}
+	}
+
+	return nil
+}
+
+// close discards the transaction even if ctx is already done.
+func (r *Runner) close(ctx context.Context, transactionID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), closeTimeout)
+	defer cancel()
+
+	if _, err := r.Client.CloseTransaction(ctx, &haproxyv1.CloseTransactionRequest{
+		TransactionId: transactionID,
+	}); err != nil {
+		klog.Errorf("close transaction error: %v", err.Error())
+	}
+}
+
+// IsConflict reports whether err means the transaction was based on an
+// outdated configuration version.
+func IsConflict(err error) bool {
+	switch status.Code(err) {
+	case codes.Aborted, codes.FailedPrecondition:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,296 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	haproxyv1 "github.com/bear-san/haproxy-configurator/pkg/haproxy/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeClient records the transaction calls made by a Runner.
+type fakeClient struct {
+	haproxyv1.HAProxyManagerServiceClient
+
+	version int64
+	// conflicts is the number of commits that lose against a concurrent
+	// writer before one succeeds.
+	conflicts    int
+	createErr    error
+	commitErr    error
+	transactions int
+	calls        []string
+	// closeCtxErrs holds the context error seen by every CloseTransaction.
+	closeCtxErrs []error
+}
+
+func (f *fakeClient) GetVersion(_ context.Context, _ *haproxyv1.GetVersionRequest, _ ...grpc.CallOption) (*haproxyv1.GetVersionResponse, error) {
+	f.calls = append(f.calls, "get version")
+	return &haproxyv1.GetVersionResponse{Version: f.version}, nil
+}
+
+func (f *fakeClient) CreateTransaction(_ context.Context, in *haproxyv1.CreateTransactionRequest, _ ...grpc.CallOption) (*haproxyv1.CreateTransactionResponse, error) {
+	f.calls = append(f.calls, fmt.Sprintf("create transaction %d", in.Version))
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	f.transactions++
+
+	return &haproxyv1.CreateTransactionResponse{
+		Transaction: &haproxyv1.Transaction{Id: fmt.Sprintf("t%d", f.transactions)},
+	}, nil
+}
+
+func (f *fakeClient) CommitTransaction(_ context.Context, in *haproxyv1.CommitTransactionRequest, _ ...grpc.CallOption) (*haproxyv1.CommitTransactionResponse, error) {
+	f.calls = append(f.calls, "commit "+in.TransactionId)
+	if f.conflicts > 0 {
+		f.conflicts--
+		// another writer committed in between
+		f.version++
+		return nil, status.Error(codes.Aborted, "version mismatch")
+	}
+	if f.commitErr != nil {
+		return nil, f.commitErr
+	}
+	f.version++
+
+	return &haproxyv1.CommitTransactionResponse{}, nil
+}
+
+func (f *fakeClient) CloseTransaction(ctx context.Context, in *haproxyv1.CloseTransactionRequest, _ ...grpc.CallOption) (*haproxyv1.CloseTransactionResponse, error) {
+	f.calls = append(f.calls, "close "+in.TransactionId)
+	f.closeCtxErrs = append(f.closeCtxErrs, ctx.Err())
+
+	return &haproxyv1.CloseTransactionResponse{}, nil
+}
+
+func testRunner(client *fakeClient) *Runner {
+	return &Runner{
+		Client:  client,
+		Backoff: wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3},
+	}
+}
+
+// step records its transaction ID in calls and fails with err.
+func step(client *fakeClient, name string, group string, err error) Step {
+	return Step{
+		Name:  name,
+		Group: group,
+		Run: func(_ context.Context, transactionID string) error {
+			client.calls = append(client.calls, name+" "+transactionID)
+			return err
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	stepErr := errors.New("invalid server")
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	for _, tt := range []struct {
+		name      string
+		client    fakeClient
+		steps     func(client *fakeClient) []Step
+		planErr   error
+		wantCalls []string
+		wantErr   error
+		// wantConflict expects the error of the last conflicting attempt
+		wantConflict bool
+		// wantStep and wantGroup are checked when the error is a StepError
+		wantStep  string
+		wantGroup string
+	}{
+		{
+			name:   "commits the steps",
+			client: fakeClient{version: 7},
+			steps: func(client *fakeClient) []Step {
+				return []Step{step(client, "create frontend", "fe", nil), step(client, "create bind", "fe", nil)}
+			},
+			wantCalls: []string{"get version", "create transaction 7", "create frontend t1", "create bind t1", "commit t1"},
+		},
+		{
+			name:      "opens no transaction for an empty plan",
+			client:    fakeClient{version: 7},
+			steps:     func(*fakeClient) []Step { return nil },
+			wantCalls: []string{"get version"},
+		},
+		{
+			name:      "opens no transaction when planning fails",
+			client:    fakeClient{version: 7},
+			planErr:   unavailable,
+			wantCalls: []string{"get version"},
+			wantErr:   unavailable,
+		},
+		{
+			name:   "closes the transaction when a step fails",
+			client: fakeClient{version: 7},
+			steps: func(client *fakeClient) []Step {
+				return []Step{
+					step(client, "create backend", "be", nil),
+					step(client, "create server", "be", stepErr),
+					step(client, "create frontend", "fe", nil),
+				}
+			},
+			wantCalls: []string{"get version", "create transaction 7", "create backend t1", "create server t1", "close t1"},
+			wantErr:   stepErr,
+			wantStep:  "create server",
+			wantGroup: "be",
+		},
+		{
+			name:   "reports a failed transaction",
+			client: fakeClient{version: 7, createErr: unavailable},
+			steps: func(client *fakeClient) []Step {
+				return []Step{step(client, "create frontend", "fe", nil)}
+			},
+			wantCalls: []string{"get version", "create transaction 7"},
+			wantErr:   unavailable,
+			wantStep:  "create transaction",
+		},
+		{
+			name:   "closes the transaction when the commit fails",
+			client: fakeClient{version: 7, commitErr: unavailable},
+			steps: func(client *fakeClient) []Step {
+				return []Step{step(client, "create frontend", "fe", nil)}
+			},
+			wantCalls: []string{"get version", "create transaction 7", "create frontend t1", "commit t1", "close t1"},
+			wantErr:   unavailable,
+			wantStep:  "commit transaction",
+		},
+		{
+			name:   "retries a conflict with the new version",
+			client: fakeClient{version: 7, conflicts: 1},
+			steps: func(client *fakeClient) []Step {
+				return []Step{step(client, "create frontend", "fe", nil)}
+			},
+			wantCalls: []string{
+				"get version", "create transaction 7", "create frontend t1", "commit t1", "close t1",
+				"get version", "create transaction 8", "create frontend t2", "commit t2",
+			},
+		},
+		{
+			name:   "gives up after the last retry",
+			client: fakeClient{version: 7, conflicts: 3},
+			steps: func(client *fakeClient) []Step {
+				return []Step{step(client, "create frontend", "fe", nil)}
+			},
+			wantCalls: []string{
+				"get version", "create transaction 7", "create frontend t1", "commit t1", "close t1",
+				"get version", "create transaction 8", "create frontend t2", "commit t2", "close t2",
+				"get version", "create transaction 9", "create frontend t3", "commit t3", "close t3",
+			},
+			wantConflict: true,
+			wantStep:     "commit transaction",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &tt.client
+
+			err := testRunner(client).Run(context.Background(), func(context.Context) ([]Step, error) {
+				if tt.planErr != nil {
+					return nil, tt.planErr
+				}
+				return tt.steps(client), nil
+			})
+
+			if !slices.Equal(client.calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", client.calls, tt.wantCalls)
+			}
+			switch {
+			case tt.wantConflict:
+				if !IsConflict(err) {
+					t.Errorf("Run() = %v, want a conflict", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Run() = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Run() = %v, want no error", err)
+				}
+				return
+			}
+			var stepError *StepError
+			if tt.wantStep == "" {
+				if errors.As(err, &stepError) {
+					t.Errorf("Run() = %v, want no StepError", err)
+				}
+				return
+			}
+			if !errors.As(err, &stepError) {
+				t.Fatalf("Run() = %v, want a StepError", err)
+			}
+			if stepError.Step != tt.wantStep || stepError.Group != tt.wantGroup {
+				t.Errorf("StepError = %q in group %q, want %q in group %q", stepError.Step, stepError.Group, tt.wantStep, tt.wantGroup)
+			}
+		})
+	}
+}
+
+func TestRunPlansEveryAttempt(t *testing.T) {
+	client := &fakeClient{version: 7, conflicts: 1}
+	var versions []int64
+
+	if err := testRunner(client).Run(context.Background(), func(context.Context) ([]Step, error) {
+		// the plan reads the configuration the last commit left behind
+		versions = append(versions, client.version)
+		return []Step{step(client, "create frontend", "fe", nil)}, nil
+	}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	if want := []int64{7, 8}; !slices.Equal(versions, want) {
+		t.Errorf("planned against versions %v, want %v", versions, want)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	client := &fakeClient{version: 7}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := testRunner(client).Run(ctx, func(context.Context) ([]Step, error) {
+		t.Error("plan called after the context was cancelled")
+		return nil, nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() = %v, want %v", err, context.Canceled)
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("calls = %q, want none", client.calls)
+	}
+}
+
+func TestRunClosesAfterCancel(t *testing.T) {
+	client := &fakeClient{version: 7}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := testRunner(client).Run(ctx, func(context.Context) ([]Step, error) {
+		return []Step{{
+			Name: "create frontend",
+			Run: func(ctx context.Context, _ string) error {
+				cancel()
+				return ctx.Err()
+			},
+		}}, nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() = %v, want %v", err, context.Canceled)
+	}
+	want := []string{"get version", "create transaction 7", "close t1"}
+	if !slices.Equal(client.calls, want) {
+		t.Errorf("calls = %q, want %q", client.calls, want)
+	}
+	if len(client.closeCtxErrs) != 1 || client.closeCtxErrs[0] != nil {
+		t.Errorf("close context errors = %v, want the transaction closed with a live context", client.closeCtxErrs)
+	}
+}